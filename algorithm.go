@@ -1,18 +1,32 @@
 package goshazam
 
 import (
+	"errors"
+	"fmt"
 	"gonum.org/v1/gonum/dsp/fourier"
+	"io"
 	"math"
 	"sync"
+	"time"
 )
 
+// hannWindow is numpy.hanning(fftSize+2) without its zero endpoints, the window of
+// the reference implementation, so that no sample of a hop is weighted with zero.
 var hannWindow []float64
 
+var (
+	// spreadOffsets are the earlier hops each spectrum is spread over.
+	spreadOffsets = [...]int{-1, -3, -6}
+	// neighborBinOffsets and neighborFFTOffsets locate the spread magnitudes a
+	// peak must exceed, in its own spectrum and in the spectra around it.
+	neighborBinOffsets = [...]int{-10, -7, -4, -3, 1, 2, 5, 8}
+	neighborFFTOffsets = [...]int{-53, -45, 165, 172, 179, 186, 193, 200, 214, 221, 228, 235, 242, 249}
+)
+
 func init() {
 	hannWindow = make([]float64, fftSize)
-	N := len(hannWindow)
-	for i := 0; i < N; i++ {
-		hannWindow[i] = 0.5 * (1 - math.Cos(2*math.Pi*float64(i)/float64(N-1)))
+	for i := range hannWindow {
+		hannWindow[i] = 0.5 * (1 - math.Cos(2*math.Pi*float64(i+1)/float64(fftSize+1)))
 	}
 }
 
@@ -31,6 +45,10 @@ type DecodedSignature struct {
 	FrequencyBandToSoundPeaks map[FrequencyBand][]FrequencyPeak
 }
 
+// SignatureGenerator computes signatures of 16 kHz mono audio. Besides the one-shot
+// MakeSignatureFrom* methods, audio can be fed incrementally with Feed; state carries
+// over between calls, so any split of the same audio yields the same signature.
+// Its methods are safe for concurrent use.
 type SignatureGenerator struct {
 	ringBufferOfSamples          []int16
 	reorderedRingBufferOfSamples []float64
@@ -41,18 +59,52 @@ type SignatureGenerator struct {
 	spreadFFTOutputsIndex        int
 	numSpreadFFTsDone            uint32
 	signature                    DecodedSignature
-	mu                           sync.Mutex
+	// fft and fftCoefficients are reused by every hop, so that analysing audio
+	// allocates nothing but the peaks it finds.
+	fft             *fourier.FFT
+	fftCoefficients []complex128
+	// hop collects fed samples until a whole hop can be analysed.
+	hop         []int16
+	hopFill     int
+	maxSamples  int
+	onSignature func(DecodedSignature)
+	mu          sync.Mutex
+}
+
+// CheckSignatureWindow returns ErrInvalidWindow when window is outside the supported bounds.
+func CheckSignatureWindow(window time.Duration) error {
+	if window < MinSignatureWindow || window > MaxSignatureWindow {
+		return fmt.Errorf("%w: window of %v is outside %v to %v", ErrInvalidWindow, window, MinSignatureWindow, MaxSignatureWindow)
+	}
+	return nil
+}
+
+func windowSamples(window time.Duration) int {
+	return int(window.Seconds() * sampleRate)
 }
 
+// NewSignatureGenerator returns a generator whose signatures cover DefaultSignatureWindow.
 func NewSignatureGenerator() *SignatureGenerator {
+	s, _ := NewSignatureGeneratorWindow(DefaultSignatureWindow)
+	return s
+}
+
+// NewSignatureGeneratorWindow returns a generator whose signatures cover window, which
+// must be within MinSignatureWindow and MaxSignatureWindow; other lengths fail with
+// ErrInvalidWindow, like everywhere else a window is configured.
+func NewSignatureGeneratorWindow(window time.Duration) (*SignatureGenerator, error) {
+	if err := CheckSignatureWindow(window); err != nil {
+		return nil, err
+	}
 	s := &SignatureGenerator{
 		ringBufferOfSamples:          make([]int16, fftSize),
 		reorderedRingBufferOfSamples: make([]float64, fftSize),
 		fftOutputs:                   make([][]float64, numFFTs),
 		spreadFFTOutputs:             make([][]float64, numFFTs),
-		signature: DecodedSignature{
-			FrequencyBandToSoundPeaks: make(map[FrequencyBand][]FrequencyPeak),
-		},
+		hop:                          make([]int16, hopSize),
+		fft:                          fourier.NewFFT(fftSize),
+		fftCoefficients:              make([]complex128, fftOutputSize),
+		maxSamples:                   windowSamples(window),
 	}
 	for i := range s.fftOutputs {
 		s.fftOutputs[i] = make([]float64, fftOutputSize)
@@ -60,33 +112,166 @@ func NewSignatureGenerator() *SignatureGenerator {
 	for i := range s.spreadFFTOutputs {
 		s.spreadFFTOutputs[i] = make([]float64, fftOutputSize)
 	}
-	return s
+	s.reset()
+	return s, nil
 }
 
-func (s *SignatureGenerator) MakeSignatureFromBuffer(s16Mono16kHzBuffer []int16) DecodedSignature {
+// reset discards all audio analysed so far and starts an empty signature.
+func (s *SignatureGenerator) reset() {
+	clear(s.ringBufferOfSamples)
+	for i := range s.fftOutputs {
+		clear(s.fftOutputs[i])
+	}
+	for i := range s.spreadFFTOutputs {
+		clear(s.spreadFFTOutputs[i])
+	}
+	s.ringBufferOfSamplesIndex = 0
+	s.fftOutputsIndex = 0
+	s.spreadFFTOutputsIndex = 0
+	s.numSpreadFFTsDone = 0
+	s.hopFill = 0
 	s.signature = DecodedSignature{
 		SampleRateHz:              sampleRate,
 		FrequencyBandToSoundPeaks: make(map[FrequencyBand][]FrequencyPeak),
 	}
+}
+
+// MakeSignatureFromBuffer computes the signature of 16 kHz mono samples. Samples of
+// any other rate or channel layout produce a meaningless signature; pass them to
+// MakeSignatureFromAudio instead. Audio fed earlier is discarded.
+func (s *SignatureGenerator) MakeSignatureFromBuffer(s16Mono16kHzBuffer []int16) DecodedSignature {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reset()
+	s.feed(s16Mono16kHzBuffer[:min(len(s16Mono16kHzBuffer), s.maxSamples)])
+	return s.flush()
+}
+
+// MakeSignatureFromAudio computes the signature of b after converting it to 16 kHz
+// mono. Buffers whose format does not describe their samples are rejected.
+func (s *SignatureGenerator) MakeSignatureFromAudio(b AudioBuffer) (DecodedSignature, error) {
+	b, err := b.ForSignature()
+	if err != nil {
+		return DecodedSignature{}, err
+	}
+	return s.MakeSignatureFromBuffer(b.Samples), nil
+}
+
+// MakeSignatureFromReader computes the signature of signed 16-bit little-endian
+// 16 kHz mono PCM read from r, such as ffmpeg's output. It reads one hop at a
+// time and stops after the fingerprinted window. Audio fed earlier is discarded.
+func (s *SignatureGenerator) MakeSignatureFromReader(r io.Reader) (DecodedSignature, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reset()
+
+	samples := NewSampleReader(r, hopSize)
+	for !s.full() {
+		chunk, err := samples.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return s.flush(), err
+		}
+		// Hops overshoot windows that are not a whole number of them.
+		s.feed(chunk[:min(len(chunk), s.maxSamples-int(s.signature.NumberSamples))])
+	}
+	return s.flush(), nil
+}
+
+// OnSignature makes Feed hand every completed window to fn and continue with a new
+// signature, which suits monitoring a stream. Without it, audio fed after a window
+// is complete is ignored until Flush. fn runs on the goroutine calling Feed.
+func (s *SignatureGenerator) OnSignature(fn func(DecodedSignature)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onSignature = fn
+}
+
+// Feed analyses the next chunk of 16 kHz mono samples, which may have any length.
+// A signature covers at most the fingerprinted window; see OnSignature and Flush.
+func (s *SignatureGenerator) Feed(samples []int16) {
+	s.mu.Lock()
+	var completed []DecodedSignature
+	for len(samples) > 0 && (!s.full() || s.onSignature != nil) {
+		n := min(len(samples), s.maxSamples-int(s.signature.NumberSamples))
+		s.feed(samples[:n])
+		samples = samples[n:]
+		if s.full() && s.onSignature != nil {
+			completed = append(completed, s.flush())
+		}
+	}
+	fn := s.onSignature
+	s.mu.Unlock()
 
-	maxSamples := int(maxTimeSeconds * float64(sampleRate))
-	if len(s16Mono16kHzBuffer) > maxSamples {
-		s16Mono16kHzBuffer = s16Mono16kHzBuffer[:maxSamples]
+	// Call back without the lock so that fn may use the generator.
+	for _, signature := range completed {
+		fn(signature)
 	}
-	s.signature.NumberSamples = uint32(len(s16Mono16kHzBuffer))
+}
+
+// Flush returns the signature of the audio fed since the last signature was emitted,
+// even if it is shorter than the fingerprinted window, and starts a new one.
+func (s *SignatureGenerator) Flush() DecodedSignature {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.flush()
+}
+
+// Window returns how much audio a signature covers at most.
+func (s *SignatureGenerator) Window() time.Duration {
+	return time.Duration(s.maxSamples) * time.Second / sampleRate
+}
 
-	for i := 0; i+128 <= len(s16Mono16kHzBuffer); i += 128 {
-		chunk := s16Mono16kHzBuffer[i : i+128]
-		s.doFFT(chunk)
-		s.doPeakSpreading()
-		s.numSpreadFFTsDone++
+// Buffered returns how much audio the signature being built covers.
+func (s *SignatureGenerator) Buffered() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return time.Duration(s.signature.NumberSamples) * time.Second / sampleRate
+}
 
-		if s.numSpreadFFTsDone >= 46 {
-			s.doPeakRecognition()
+// feed analyses samples hop by hop, keeping a trailing partial hop for the next call.
+// The caller keeps the signature within the fingerprinted window.
+func (s *SignatureGenerator) feed(samples []int16) {
+	s.signature.NumberSamples += uint32(len(samples))
+	for len(samples) > 0 {
+		if s.hopFill == 0 && len(samples) >= hopSize {
+			s.processHop(samples[:hopSize])
+			samples = samples[hopSize:]
+			continue
+		}
+		n := copy(s.hop[s.hopFill:], samples)
+		s.hopFill += n
+		samples = samples[n:]
+		if s.hopFill == hopSize {
+			s.processHop(s.hop)
+			s.hopFill = 0
 		}
+	}
+}
+
+func (s *SignatureGenerator) full() bool {
+	return int(s.signature.NumberSamples) >= s.maxSamples
+}
+
+// flush returns the current signature and starts a new one. A trailing partial hop
+// counts towards the length but is not analysed.
+func (s *SignatureGenerator) flush() DecodedSignature {
+	signature := s.signature
+	s.reset()
+	return signature
+}
 
+// processHop analyses the next hopSize samples.
+func (s *SignatureGenerator) processHop(chunk []int16) {
+	s.doFFT(chunk)
+	s.doPeakSpreading()
+	s.numSpreadFFTsDone++
+
+	if s.numSpreadFFTsDone >= peakDelay {
+		s.doPeakRecognition()
 	}
-	return s.signature
 }
 
 func (s *SignatureGenerator) doFFT(s16Mono16kHzBuffer []int16) {
@@ -100,8 +285,7 @@ func (s *SignatureGenerator) doFFT(s16Mono16kHzBuffer []int16) {
 	for i := 0; i < fftSize; i++ {
 		s.reorderedRingBufferOfSamples[i] = float64(s.ringBufferOfSamples[(startIndex+i)%fftSize]) * hannWindow[i]
 	}
-	fft := fourier.NewFFT(fftSize)
-	complexFFTResults := fft.Coefficients(nil, s.reorderedRingBufferOfSamples)
+	complexFFTResults := s.fft.Coefficients(s.fftCoefficients, s.reorderedRingBufferOfSamples)
 	realFFTResults := s.fftOutputs[s.fftOutputsIndex]
 
 	for i := 0; i < fftOutputSize; i++ {
@@ -113,46 +297,30 @@ func (s *SignatureGenerator) doFFT(s16Mono16kHzBuffer []int16) {
 	s.fftOutputsIndex = (s.fftOutputsIndex + 1) % numFFTs
 }
 
+// doPeakSpreading spreads the latest spectrum over neighbouring bins into the oldest
+// slot of spreadFFTOutputs, then over the earlier spectra 1, 3 and 6 hops back.
 func (s *SignatureGenerator) doPeakSpreading() {
 	fftOutputsPosition := (s.fftOutputsIndex - 1 + numFFTs) % numFFTs
 	originLastFFT := s.fftOutputs[fftOutputsPosition]
 
-	temporaryArray1 := make([][]float64, 3)
-	for i := 0; i < 3; i++ {
-		temporaryArray1[i] = originLastFFT
-	}
-	temporaryArray1[1] = append(temporaryArray1[1][1:], temporaryArray1[1][:1]...)
-	temporaryArray1[2] = append(temporaryArray1[2][2:], temporaryArray1[2][:2]...)
-
-	originLastFFTSp := make([]float64, fftOutputSize)
+	originLastFFTSp := s.spreadFFTOutputs[s.spreadFFTOutputsIndex]
 	for i := 0; i < fftOutputSize-3; i++ {
 		originLastFFTSp[i] = math.Max(
-			temporaryArray1[0][i],
-			math.Max(temporaryArray1[1][i], temporaryArray1[2][i]),
+			originLastFFT[i],
+			math.Max(originLastFFT[i+1], originLastFFT[i+2]),
 		)
 	}
 	copy(originLastFFTSp[fftOutputSize-3:], originLastFFT[fftOutputSize-3:])
 
-	offsets := []int{-1, -3, -6}
-	positions := make([]int, len(offsets))
-	for i, offset := range offsets {
-		positions[i] = (s.spreadFFTOutputsIndex + offset + numFFTs) % numFFTs
-	}
-
-	temporaryArray2 := make([][]float64, 4)
-	temporaryArray2[0] = originLastFFTSp
-	for i, pos := range positions {
-		temporaryArray2[i+1] = s.spreadFFTOutputs[pos]
-	}
-
-	for i := 1; i <= 3; i++ {
+	previous := originLastFFTSp
+	for _, offset := range spreadOffsets {
+		spread := s.spreadFFTOutputs[(s.spreadFFTOutputsIndex+offset+numFFTs)%numFFTs]
 		for j := 0; j < fftOutputSize; j++ {
-			temporaryArray2[i][j] = math.Max(temporaryArray2[i][j], temporaryArray2[i-1][j])
+			spread[j] = math.Max(spread[j], previous[j])
 		}
-		s.spreadFFTOutputs[positions[i-1]] = temporaryArray2[i]
+		previous = spread
 	}
 
-	s.spreadFFTOutputs[s.spreadFFTOutputsIndex] = originLastFFTSp
 	s.spreadFFTOutputsIndex = (s.spreadFFTOutputsIndex + 1) % numFFTs
 }
 
@@ -165,7 +333,7 @@ func (s *SignatureGenerator) doPeakRecognition() {
 		isLocalMax := fftMinus46[binPosition] >= fftMinus49[binPosition-1]
 		if isMagnitudeAboveThreshold && isLocalMax {
 			var maxNeighborInFFTMinus49 float64
-			for _, offset := range []int{-10, -7, -4, -3, 1, 2, 5, 8} {
+			for _, offset := range neighborBinOffsets {
 				neighborIndex := binPosition + offset
 				if neighborIndex >= 0 && neighborIndex < fftOutputSize {
 					maxNeighborInFFTMinus49 = math.Max(maxNeighborInFFTMinus49, fftMinus49[neighborIndex])
@@ -173,7 +341,7 @@ func (s *SignatureGenerator) doPeakRecognition() {
 			}
 			if fftMinus46[binPosition] > maxNeighborInFFTMinus49 {
 				maxNeighborInOtherAdjacentFFTs := maxNeighborInFFTMinus49
-				for _, offset := range []int{-53, -45, 165, 172, 179, 186, 193, 200, 214, 221, 228, 235, 242, 249} {
+				for _, offset := range neighborFFTOffsets {
 					idx := (s.spreadFFTOutputsIndex + offset + numFFTs) % numFFTs
 					otherFFT := s.spreadFFTOutputs[idx]
 					binIdx := binPosition - 1
@@ -182,34 +350,20 @@ func (s *SignatureGenerator) doPeakRecognition() {
 					}
 				}
 				if fftMinus46[binPosition] > maxNeighborInOtherAdjacentFFTs {
-					fftPassNumber := s.numSpreadFFTsDone - 46
-					peakMagnitude := math.Log(math.Max(minPeakMagnitude, fftMinus46[binPosition]))*1477.4 + 6144.0
+					fftPassNumber := s.numSpreadFFTsDone - peakDelay
+					peakMagnitude := logMagnitude(fftMinus46[binPosition])
 
-					peakMagnitudeBefore := math.Log(math.Max(minPeakMagnitude, fftMinus46[binPosition-1]))*1477.4 + 6144.0
-					peakMagnitudeAfter := math.Log(math.Max(minPeakMagnitude, fftMinus46[binPosition+1]))*1477.4 + 6144.0
+					peakMagnitudeBefore := logMagnitude(fftMinus46[binPosition-1])
+					peakMagnitudeAfter := logMagnitude(fftMinus46[binPosition+1])
 
 					peakVariation1 := peakMagnitude*2.0 - peakMagnitudeBefore - peakMagnitudeAfter
 					if peakVariation1 <= 0 {
 						continue
 					}
 					peakVariation2 := (peakMagnitudeAfter - peakMagnitudeBefore) * 32.0 / peakVariation1
-					correctedPeakFrequencyBin := uint16(binPosition*64) + uint16(peakVariation2+0.5)
-
-					frequencyHz := float64(correctedPeakFrequencyBin) * (16000.0 / 2.0 / 1024.0 / 64.0)
-					var frequencyBand FrequencyBand
-					switch {
-					case frequencyHz > 250 && frequencyHz < 520:
-						frequencyBand = _250_520
-					case frequencyHz >= 520 && frequencyHz < 1450:
-						frequencyBand = _520_1450
-					case frequencyHz >= 1450 && frequencyHz < 3500:
-						frequencyBand = _1450_3500
-					case frequencyHz >= 3500 && frequencyHz <= 5500:
-						frequencyBand = _3500_5500
-					default:
-						continue
-					}
-					if frequencyBand == _3500_5500 {
+					correctedPeakFrequencyBin := correctedPeakBin(binPosition, peakVariation2)
+					frequencyBand, ok := peakFrequencyBand(correctedPeakFrequencyBin)
+					if !ok {
 						continue
 					}
 					s.signature.FrequencyBandToSoundPeaks[frequencyBand] = append(
@@ -225,3 +379,37 @@ func (s *SignatureGenerator) doPeakRecognition() {
 		}
 	}
 }
+
+// logMagnitude converts an FFT magnitude to the logarithmic scale of peaks.
+// The conversion keeps the multiplication from being fused with the addition,
+// which some platforms would otherwise do.
+func logMagnitude(magnitude float64) float64 {
+	return float64(math.Log(math.Max(minPeakMagnitude, magnitude))*1477.3) + 6144
+}
+
+// correctedPeakBin returns a peak's frequency in 64ths of an FFT bin. Like SongRec,
+// it truncates the interpolated variation before adding it. shazamio truncates the
+// sum instead, which is one lower for negative variations.
+func correctedPeakBin(binPosition int, variation float64) uint16 {
+	return uint16(binPosition*64 + int(variation))
+}
+
+// peakFrequencyBand returns the band of a corrected peak bin, or false outside
+// 250-5500 Hz. As in SongRec, 250 Hz itself belongs to the lowest band, while
+// shazamio starts just above it.
+func peakFrequencyBand(correctedBin uint16) (FrequencyBand, bool) {
+	frequencyHz := float64(correctedBin) * (16000.0 / 2.0 / 1024.0 / 64.0)
+	switch {
+	case frequencyHz < 250:
+		return 0, false
+	case frequencyHz < 520:
+		return _250_520, true
+	case frequencyHz < 1450:
+		return _520_1450, true
+	case frequencyHz < 3500:
+		return _1450_3500, true
+	case frequencyHz <= 5500:
+		return _3500_5500, true
+	}
+	return 0, false
+}
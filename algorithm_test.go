@@ -0,0 +1,358 @@
+package goshazam
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the signature snapshots in testdata/snapshot and the reference inputs in testdata/reference")
+
+// testNoise returns d of 16 kHz white noise at about -20 dBFS from a fixed seed.
+func testNoise(d time.Duration, seed int64) []int16 {
+	r := rand.New(rand.NewSource(seed))
+	samples := make([]int16, windowSamples(d))
+	for i := range samples {
+		samples[i] = int16(r.NormFloat64() * 3000)
+	}
+	return samples
+}
+
+// testTones returns d of 16 kHz tone pips, 50 ms long with a Hann envelope and
+// 250 ms apart, cycling through one frequency in every band.
+func testTones(d time.Duration) []int16 {
+	frequencies := []float64{400, 1000, 2500, 4500}
+	samples := make([]int16, windowSamples(d))
+	pip, period := sampleRate/20, sampleRate/4
+	for i := range samples {
+		n, at := i/period, i%period
+		if at < pip {
+			envelope := 0.5 * (1 - math.Cos(2*math.Pi*float64(at)/float64(pip)))
+			samples[i] = int16(8000 * envelope * math.Sin(2*math.Pi*frequencies[n%len(frequencies)]*float64(i)/sampleRate))
+		}
+	}
+	return samples
+}
+
+// testSweep returns d of a 16 kHz exponential sweep from 200 Hz to 6 kHz.
+func testSweep(d time.Duration) []int16 {
+	const from, to = 200.0, 6000.0
+	samples := make([]int16, windowSamples(d))
+	length := d.Seconds()
+	k := math.Log(to / from)
+	for i := range samples {
+		t := float64(i) / sampleRate
+		phase := 2 * math.Pi * from * length / k * (math.Exp(t/length*k) - 1)
+		samples[i] = int16(8000 * math.Sin(phase))
+	}
+	return samples
+}
+
+// signatureCorpus is the input of TestSignatureSnapshots and TestReferenceSignatures.
+var signatureCorpus = []struct {
+	name    string
+	samples func() []int16
+}{
+	{"tones", func() []int16 { return testTones(DefaultSignatureWindow) }},
+	{"sweep", func() []int16 { return testSweep(DefaultSignatureWindow) }},
+	{"noise", func() []int16 { return testNoise(DefaultSignatureWindow, 1) }},
+}
+
+// TestSignatureSnapshots compares the signatures of the corpus with regression snapshots
+// in testdata/snapshot. The snapshots are this implementation's own output, so they catch
+// unintended changes but say nothing about compatibility; TestReferenceSignatures does.
+// Rewrite them with -update only for intended changes to the algorithm.
+func TestSignatureSnapshots(t *testing.T) {
+	for _, c := range signatureCorpus {
+		t.Run(c.name, func(t *testing.T) {
+			signature := NewSignatureGenerator().MakeSignatureFromBuffer(c.samples())
+			encoded, err := signature.EncodeToBinary()
+			if err != nil {
+				t.Fatal(err)
+			}
+			peaksPath := filepath.Join("testdata", "snapshot", c.name+".peaks")
+			binaryPath := filepath.Join("testdata", "snapshot", c.name+".sig")
+			if *update {
+				writeTestFile(t, peaksPath, []byte(formatPeaks(signature)))
+				writeTestFile(t, binaryPath, encoded)
+				return
+			}
+			comparePeaks(t, signature, peaksPath)
+			compareBinary(t, encoded, binaryPath)
+		})
+	}
+}
+
+// TestReferenceSignatures compares the signatures of the corpus with the output of a
+// reference implementation, which testdata/reference/shazamio.py produces from the
+// corpus written as raw PCM next to it. Corpus entries without reference output are
+// skipped.
+func TestReferenceSignatures(t *testing.T) {
+	for _, c := range signatureCorpus {
+		t.Run(c.name, func(t *testing.T) {
+			samples := c.samples()
+			inputPath := filepath.Join("testdata", "reference", c.name+".s16")
+			var input bytes.Buffer
+			if err := binary.Write(&input, binary.LittleEndian, samples); err != nil {
+				t.Fatal(err)
+			}
+			if *update {
+				writeTestFile(t, inputPath, input.Bytes())
+				return
+			}
+			want, err := os.ReadFile(inputPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The reference was computed from the checked-in input, which must still be the corpus.
+			if !bytes.Equal(input.Bytes(), want) {
+				t.Fatalf("corpus differs from %s; rewrite it with -update and regenerate the reference", inputPath)
+			}
+
+			peaksPath := filepath.Join("testdata", "reference", c.name+".peaks")
+			binaryPath := filepath.Join("testdata", "reference", c.name+".sig")
+			if _, err := os.Stat(peaksPath); errors.Is(err, fs.ErrNotExist) {
+				t.Skipf("no reference output in %s", peaksPath)
+			}
+			signature := NewSignatureGenerator().MakeSignatureFromBuffer(samples)
+			encoded, err := signature.EncodeToBinary()
+			if err != nil {
+				t.Fatal(err)
+			}
+			comparePeaks(t, signature, peaksPath)
+			compareBinary(t, encoded, binaryPath)
+		})
+	}
+}
+
+func writeTestFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// comparePeaks compares the peaks of signature with the peak list at path.
+func comparePeaks(t *testing.T, signature DecodedSignature, path string) {
+	t.Helper()
+	want, err := readPeaks(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := sortedPeaks(signature)
+	if len(got) != len(want) {
+		t.Errorf("got %d peaks, want %d", len(got), len(want))
+	}
+	for i := range min(len(got), len(want)) {
+		g, w := got[i], want[i]
+		// Magnitudes are compared as encoded, plus a tolerance for the last bits of the FFT.
+		if g.band != w.band || g.FFTPassNumber != w.FFTPassNumber || g.CorrectedPeakFrequencyBin != w.CorrectedPeakFrequencyBin ||
+			uint16(g.PeakMagnitude) != uint16(w.PeakMagnitude) || math.Abs(g.PeakMagnitude-w.PeakMagnitude) > 1e-6 {
+			t.Fatalf("peak %d is %+v, want %+v", i, g, w)
+		}
+	}
+}
+
+// compareBinary compares an encoded signature with the one at path.
+func compareBinary(t *testing.T, encoded []byte, path string) {
+	t.Helper()
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(encoded, want) {
+		t.Errorf("EncodeToBinary differs from %s", path)
+	}
+}
+
+type bandPeak struct {
+	band FrequencyBand
+	FrequencyPeak
+}
+
+// sortedPeaks lists the peaks of all bands, band by band.
+func sortedPeaks(signature DecodedSignature) []bandPeak {
+	var peaks []bandPeak
+	for band := _250_520; band <= _3500_5500; band++ {
+		for _, peak := range signature.FrequencyBandToSoundPeaks[band] {
+			peaks = append(peaks, bandPeak{band, peak})
+		}
+	}
+	return peaks
+}
+
+// formatPeaks writes one peak per line: band, FFT pass, corrected bin and magnitude.
+func formatPeaks(signature DecodedSignature) string {
+	var b strings.Builder
+	for _, p := range sortedPeaks(signature) {
+		fmt.Fprintf(&b, "%d %d %d %.9f\n", p.band, p.FFTPassNumber, p.CorrectedPeakFrequencyBin, p.PeakMagnitude)
+	}
+	return b.String()
+}
+
+func readPeaks(path string) ([]bandPeak, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var peaks []bandPeak
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		p := bandPeak{FrequencyPeak: FrequencyPeak{SampleRateHz: sampleRate}}
+		if _, err := fmt.Sscanf(scanner.Text(), "%d %d %d %f", &p.band, &p.FFTPassNumber, &p.CorrectedPeakFrequencyBin, &p.PeakMagnitude); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, len(peaks)+1, err)
+		}
+		peaks = append(peaks, p)
+	}
+	return peaks, scanner.Err()
+}
+
+func TestSignatureKeepsAllBands(t *testing.T) {
+	signature := NewSignatureGenerator().MakeSignatureFromBuffer(testTones(DefaultSignatureWindow))
+	for band := _250_520; band <= _3500_5500; band++ {
+		if len(signature.FrequencyBandToSoundPeaks[band]) == 0 {
+			t.Errorf("no peaks in band %d", band)
+		}
+	}
+}
+
+func TestHannWindow(t *testing.T) {
+	// numpy.hanning(2050)[1:-1]: no zero endpoints, symmetric around the middle.
+	if want := 0.5 * (1 - math.Cos(2*math.Pi/(fftSize+1))); hannWindow[0] != want {
+		t.Errorf("hannWindow[0] = %v, want %v", hannWindow[0], want)
+	}
+	for i := 0; i < fftSize/2; i++ {
+		if math.Abs(hannWindow[i]-hannWindow[fftSize-1-i]) > 1e-15 {
+			t.Fatalf("hannWindow is not symmetric at %d", i)
+		}
+	}
+	if want := 0.5 * (1 - math.Cos(2*math.Pi*fftSize/2/(fftSize+1))); hannWindow[fftSize/2-1] != want {
+		t.Errorf("hannWindow[%d] = %v, want %v", fftSize/2-1, hannWindow[fftSize/2-1], want)
+	}
+}
+
+func TestLogMagnitude(t *testing.T) {
+	for _, c := range []struct{ magnitude, want float64 }{
+		{1, 6144},
+		{math.E, 6144 + 1477.3},
+		{math.E * math.E, 6144 + 2*1477.3},
+		// Magnitudes below the peak threshold are clamped to it.
+		{0, 6144 + 1477.3*math.Log(minPeakMagnitude)},
+	} {
+		if got := logMagnitude(c.magnitude); math.Abs(got-c.want) > 1e-9 {
+			t.Errorf("logMagnitude(%v) = %v, want %v", c.magnitude, got, c.want)
+		}
+	}
+}
+
+func TestCorrectedPeakBin(t *testing.T) {
+	for _, c := range []struct {
+		bin       int
+		variation float64
+		want      uint16
+	}{
+		{100, 0, 6400},
+		{100, 10.7, 6410},
+		// SongRec truncates the variation toward zero; shazamio would give 6389.
+		{100, -10.7, 6390},
+		{10, -31.9, 609},
+	} {
+		if got := correctedPeakBin(c.bin, c.variation); got != c.want {
+			t.Errorf("correctedPeakBin(%d, %v) = %d, want %d", c.bin, c.variation, got, c.want)
+		}
+	}
+}
+
+func TestPeakFrequencyBand(t *testing.T) {
+	// A corrected bin is 1/8192 of 8 kHz, so 2048 is exactly 250 Hz.
+	for _, c := range []struct {
+		bin  uint16
+		band FrequencyBand
+		ok   bool
+	}{
+		{2047, 0, false},
+		// 250 Hz itself is kept as in SongRec; shazamio drops it.
+		{2048, _250_520, true},
+		{4259, _250_520, true},
+		{4260, _520_1450, true},
+		{11878, _520_1450, true},
+		{11879, _1450_3500, true},
+		{28671, _1450_3500, true},
+		{28672, _3500_5500, true},
+		{45056, _3500_5500, true},
+		{45057, 0, false},
+	} {
+		band, ok := peakFrequencyBand(c.bin)
+		if band != c.band || ok != c.ok {
+			t.Errorf("peakFrequencyBand(%d) = %d, %v, want %d, %v", c.bin, band, ok, c.band, c.ok)
+		}
+	}
+}
+
+func TestProcessHopAllocs(t *testing.T) {
+	samples := testNoise(4*time.Second, 1)
+	s := NewSignatureGenerator()
+	// Warm up until every hop also recognizes peaks.
+	hop := 0
+	for ; hop < numFFTs; hop++ {
+		s.processHop(samples[hop*hopSize : (hop+1)*hopSize])
+	}
+	// Growing the peak lists is the signature's memory, not the hop's.
+	for band := _250_520; band <= _3500_5500; band++ {
+		peaks := s.signature.FrequencyBandToSoundPeaks[band]
+		s.signature.FrequencyBandToSoundPeaks[band] = append(make([]FrequencyPeak, 0, len(peaks)+4096), peaks...)
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		s.processHop(samples[hop*hopSize : (hop+1)*hopSize])
+		hop++
+	})
+	if allocs != 0 {
+		t.Errorf("processHop allocates %v times per hop, want 0", allocs)
+	}
+}
+
+func BenchmarkSignatureGenerator(b *testing.B) {
+	samples := testNoise(DefaultSignatureWindow, 1)
+	s := NewSignatureGenerator()
+	b.ReportAllocs()
+	b.SetBytes(int64(len(samples) * 2))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.MakeSignatureFromBuffer(samples)
+	}
+}
+
+func BenchmarkProcessHop(b *testing.B) {
+	samples := testNoise(DefaultSignatureWindow, 1)
+	hops := len(samples) / hopSize
+	s := NewSignatureGenerator()
+	b.ReportAllocs()
+	b.SetBytes(hopSize * 2)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if i%hops == 0 {
+			// Keep the signature within its window so that only the hop is measured.
+			b.StopTimer()
+			s.reset()
+			b.StartTimer()
+		}
+		h := i % hops
+		s.processHop(samples[h*hopSize : (h+1)*hopSize])
+	}
+}
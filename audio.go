@@ -2,32 +2,353 @@ package goshazam
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
+	"encoding/json"
+	"fmt"
 	ffmpeg "github.com/u2takey/ffmpeg-go"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 )
 
+const (
+	defaultFFmpegPath  = "ffmpeg"
+	defaultFFprobePath = "ffprobe"
+	ffmpegWaitDelay    = time.Second
+	ffmpegReadChunk    = 4096 // samples
+)
+
+var pcmOutputArgs = ffmpeg.KwArgs{
+	"f":      "s16le",
+	"acodec": "pcm_s16le",
+	"ar":     "16000",
+	"ac":     "1",
+}
+
+// FFmpegError is returned when ffmpeg cannot be started or exits with a non-zero status.
+// Use errors.Is(err, exec.ErrNotFound) to detect a missing binary.
+type FFmpegError struct {
+	Path   string
+	Stderr string
+	Err    error
+}
+
+func (e *FFmpegError) Error() string {
+	if e.Stderr == "" {
+		return fmt.Sprintf("ffmpeg (%s): %v", e.Path, e.Err)
+	}
+	return fmt.Sprintf("ffmpeg (%s): %v: %s", e.Path, e.Err, e.Stderr)
+}
+
+func (e *FFmpegError) Unwrap() error {
+	return e.Err
+}
+
+// FFmpegDecoder decodes any format ffmpeg understands by running it as a subprocess.
+type FFmpegDecoder struct {
+	// Path is the ffmpeg binary; it defaults to "ffmpeg" on PATH.
+	Path string
+	// ProbePath is the ffprobe binary used to list streams. It defaults to
+	// "ffprobe" next to Path, or on PATH when Path has no directory.
+	ProbePath string
+}
+
+// Decode pipes r into ffmpeg's stdin.
+func (d *FFmpegDecoder) Decode(ctx context.Context, r io.Reader, opts DecodeOptions) ([]int16, error) {
+	return d.decodeSamples(ctx, "pipe:", r, opts)
+}
+
+// DecodeFile passes path to ffmpeg so it can seek in the file itself.
+func (d *FFmpegDecoder) DecodeFile(ctx context.Context, path string, opts DecodeOptions) ([]int16, error) {
+	return d.decodeSamples(ctx, path, nil, opts)
+}
+
+// DecodeStream pipes r into ffmpeg and hands over its output while it is produced.
+// Closing the returned reader stops ffmpeg; ffmpeg failures are returned by Read
+// once the output ends.
+func (d *FFmpegDecoder) DecodeStream(ctx context.Context, r io.Reader, opts DecodeOptions) (io.ReadCloser, error) {
+	return d.stream(ctx, "pipe:", r, opts), nil
+}
+
+// decodeFileStream is DecodeStream passing path to ffmpeg, like DecodeFile.
+func (d *FFmpegDecoder) decodeFileStream(ctx context.Context, path string, opts DecodeOptions) (io.ReadCloser, error) {
+	return d.stream(ctx, path, nil, opts), nil
+}
+
+// DecodeLive is DecodeStream for all of r.
+func (d *FFmpegDecoder) DecodeLive(ctx context.Context, r io.Reader) (io.ReadCloser, error) {
+	return d.stream(ctx, "pipe:", r, DecodeOptions{}), nil
+}
+
+// stream runs ffmpeg like run and returns its output as it is produced.
+func (d *FFmpegDecoder) stream(ctx context.Context, inputFile string, r io.Reader, opts DecodeOptions) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(d.run(ctx, inputFile, r, opts, func(stdout io.Reader) error {
+			_, err := io.Copy(pw, stdout)
+			return err
+		}))
+	}()
+	return pr
+}
+
+func (d *FFmpegDecoder) path() string {
+	if d.Path == "" {
+		return defaultFFmpegPath
+	}
+	return d.Path
+}
+
+func (d *FFmpegDecoder) probePath() string {
+	if d.ProbePath != "" {
+		return d.ProbePath
+	}
+	dir, name := filepath.Split(d.path())
+	if dir == "" {
+		return defaultFFprobePath
+	}
+	// Keep the platform suffix of the ffmpeg binary, e.g. ffmpeg.exe -> ffprobe.exe.
+	return filepath.Join(dir, strings.Replace(name, "ffmpeg", "ffprobe", 1))
+}
+
+type ffprobeOutput struct {
+	Format struct {
+		FormatName string            `json:"format_name"`
+		Duration   string            `json:"duration"`
+		BitRate    string            `json:"bit_rate"`
+		Tags       map[string]string `json:"tags"`
+	} `json:"format"`
+	Streams []struct {
+		Index       int               `json:"index"`
+		CodecName   string            `json:"codec_name"`
+		SampleRate  string            `json:"sample_rate"`
+		Channels    int               `json:"channels"`
+		Tags        map[string]string `json:"tags"`
+		Disposition struct {
+			Default int `json:"default"`
+		} `json:"disposition"`
+	} `json:"streams"`
+}
+
+// runProbe runs ffprobe with args and returns its JSON output.
+func (d *FFmpegDecoder) runProbe(ctx context.Context, args ...string) ([]byte, error) {
+	stdout := bytes.NewBuffer(nil)
+	stderr := bytes.NewBuffer(nil)
+	cmd := exec.CommandContext(ctx, d.probePath(), append([]string{"-hide_banner", "-loglevel", "error", "-of", "json"}, args...)...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.WaitDelay = ffmpegWaitDelay
+	if err := cmd.Run(); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, &FFmpegError{
+			Path:   d.probePath(),
+			Stderr: strings.TrimSpace(stderr.String()),
+			Err:    err,
+		}
+	}
+	return stdout.Bytes(), nil
+}
+
+// AudioStreams lists the audio streams of the file at path using ffprobe.
+func (d *FFmpegDecoder) AudioStreams(ctx context.Context, path string) ([]AudioStream, error) {
+	data, err := d.runProbe(ctx,
+		"-select_streams", "a",
+		"-show_entries", "stream=index,codec_name,sample_rate,channels:stream_tags=language,title:stream_disposition=default",
+		path)
+	if err != nil {
+		return nil, err
+	}
+
+	var out ffprobeOutput
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, fmt.Errorf("failed to parse ffprobe output: %w", err)
+	}
+	streams := make([]AudioStream, len(out.Streams))
+	for i, s := range out.Streams {
+		rate, _ := strconv.Atoi(s.SampleRate)
+		streams[i] = AudioStream{
+			Index:      s.Index,
+			AudioIndex: i,
+			Codec:      s.CodecName,
+			SampleRate: rate,
+			Channels:   s.Channels,
+			Language:   s.Tags["language"],
+			Title:      s.Tags["title"],
+			Default:    s.Disposition.Default != 0,
+		}
+	}
+	return streams, nil
+}
+
+// Probe describes the file at path and its first audio stream using ffprobe.
+// Stream tags, which Ogg and some MP4 files use, fill in missing container tags.
+func (d *FFmpegDecoder) Probe(ctx context.Context, path string) (*MediaInfo, error) {
+	data, err := d.runProbe(ctx,
+		"-select_streams", "a:0",
+		"-show_entries", "format=format_name,duration,bit_rate:format_tags:stream=codec_name,sample_rate,channels:stream_tags",
+		path)
+	if err != nil {
+		return nil, err
+	}
+
+	var out ffprobeOutput
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, fmt.Errorf("failed to parse ffprobe output: %w", err)
+	}
+	if len(out.Streams) == 0 {
+		return nil, fmt.Errorf("%w: no audio stream in %s", ErrStreamNotFound, path)
+	}
+	stream := out.Streams[0]
+	seconds, _ := strconv.ParseFloat(out.Format.Duration, 64)
+	rate, _ := strconv.Atoi(stream.SampleRate)
+	bitrate, _ := strconv.Atoi(out.Format.BitRate)
+
+	tags := make(map[string]string)
+	for key, value := range out.Format.Tags {
+		addTag(tags, key, value)
+	}
+	for key, value := range stream.Tags {
+		if _, ok := tags[strings.ToLower(key)]; !ok {
+			addTag(tags, key, value)
+		}
+	}
+	return &MediaInfo{
+		Duration:   time.Duration(seconds * float64(time.Second)),
+		Container:  out.Format.FormatName,
+		Codec:      stream.CodecName,
+		SampleRate: rate,
+		Channels:   stream.Channels,
+		Bitrate:    bitrate,
+		Tags:       tags,
+	}, nil
+}
+
+func ffmpegInputArgs(opts DecodeOptions) ffmpeg.KwArgs {
+	args := ffmpeg.KwArgs{}
+	if opts.Offset > 0 {
+		args["ss"] = formatSeconds(opts.Offset)
+	}
+	return args
+}
+
+func ffmpegOutputArgs(opts DecodeOptions) ffmpeg.KwArgs {
+	args := ffmpeg.MergeKwArgs([]ffmpeg.KwArgs{pcmOutputArgs})
+	if opts.Duration > 0 {
+		args["t"] = formatSeconds(opts.Duration)
+	}
+	if index, ok := opts.Stream(); ok {
+		// Without a map ffmpeg picks the "best" audio stream on its own.
+		args["map"] = fmt.Sprintf("0:a:%d", index)
+	}
+	return args
+}
+
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', -1, 64)
+}
+
 func GenerateRawPCMInMemory(inputFile string) (*bytes.Buffer, error) {
+	return (&FFmpegDecoder{}).generateRawPCM(context.Background(), inputFile, nil, DecodeOptions{})
+}
+
+// GenerateRawPCMFromReader decodes audio read from r by piping it into ffmpeg's stdin.
+func GenerateRawPCMFromReader(r io.Reader) (*bytes.Buffer, error) {
+	return (&FFmpegDecoder{}).generateRawPCM(context.Background(), "pipe:", r, DecodeOptions{})
+}
+
+// generateRawPCM runs ffmpeg on inputFile, or on stdin when r is not nil, and
+// collects its output in memory.
+func (d *FFmpegDecoder) generateRawPCM(ctx context.Context, inputFile string, r io.Reader, opts DecodeOptions) (*bytes.Buffer, error) {
 	buf := bytes.NewBuffer(nil)
-	err := ffmpeg.Input(inputFile).
-		Output("pipe:", ffmpeg.KwArgs{
-			"f":      "s16le",
-			"acodec": "pcm_s16le",
-			"ar":     "16000",
-			"ac":     "1",
-		}).
-		WithOutput(buf).
-		Run()
+	err := d.run(ctx, inputFile, r, opts, func(stdout io.Reader) error {
+		_, err := buf.ReadFrom(stdout)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
 	return buf, nil
 }
 
-func ReadSamplesFromBuffer(buf *bytes.Buffer) ([]int16, error) {
-	samples := make([]int16, len(buf.Bytes())/2)
-	err := binary.Read(buf, binary.LittleEndian, samples)
+// decodeSamples runs ffmpeg like generateRawPCM but converts its output while
+// it is produced, so the raw bytes are never held in memory all at once.
+func (d *FFmpegDecoder) decodeSamples(ctx context.Context, inputFile string, r io.Reader, opts DecodeOptions) ([]int16, error) {
+	var samples []int16
+	err := d.run(ctx, inputFile, r, opts, func(stdout io.Reader) error {
+		var err error
+		samples, err = NewSampleReader(stdout, ffmpegReadChunk).ReadAll()
+		return err
+	})
+	return samples, err
+}
+
+// run runs ffmpeg on inputFile, or on stdin when r is not nil, and hands its
+// stdout to consume. The offset is applied as an input seek and the duration
+// stops ffmpeg as soon as enough audio has been produced. The process is
+// killed when ctx is done.
+func (d *FFmpegDecoder) run(ctx context.Context, inputFile string, r io.Reader, opts DecodeOptions, consume func(stdout io.Reader) error) error {
+	stderr := bytes.NewBuffer(nil)
+	stream := ffmpeg.Input(inputFile, ffmpegInputArgs(opts)).
+		Output("pipe:", ffmpegOutputArgs(opts)).
+		GlobalArgs("-hide_banner", "-loglevel", "error")
+	stream.Context = ctx
+	stream = stream.SetFfmpegPath(d.path()).
+		WithErrorOutput(stderr)
+	if r != nil {
+		stream = stream.WithInput(r)
+	}
+	cmd := stream.Compile()
+	// Don't wait forever on pipes held open by grandchildren after the process is killed.
+	cmd.WaitDelay = ffmpegWaitDelay
+	stdout, err := cmd.StdoutPipe()
 	if err != nil {
-		return nil, err
+		return err
+	}
+
+	fail := func(err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		return &FFmpegError{
+			Path:   d.path(),
+			Stderr: strings.TrimSpace(stderr.String()),
+			Err:    err,
+		}
+	}
+	if err := cmd.Start(); err != nil {
+		return fail(err)
+	}
+	consumeErr := consume(stdout)
+	if consumeErr != nil {
+		// Stop ffmpeg rather than let it block on a pipe nobody reads.
+		_ = cmd.Process.Kill()
+	}
+	waitErr := cmd.Wait()
+	if consumeErr != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		return fmt.Errorf("failed to read ffmpeg output: %w", consumeErr)
+	}
+	if waitErr != nil {
+		return fail(waitErr)
+	}
+	return nil
+}
+
+// ReadSamplesFromBuffer converts the signed 16-bit little-endian PCM in buf to
+// samples, consuming it. Use SampleReader to convert PCM while it is read.
+func ReadSamplesFromBuffer(buf *bytes.Buffer) ([]int16, error) {
+	samples := make([]int16, buf.Len()/2)
+	data := buf.Next(len(samples) * 2)
+	for i := range samples {
+		samples[i] = int16(binary.LittleEndian.Uint16(data[i*2:]))
 	}
 	return samples, nil
 }
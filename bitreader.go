@@ -0,0 +1,84 @@
+package goshazam
+
+import (
+	"io"
+	"math/bits"
+)
+
+// bitReader reads big-endian bit fields from a byte stream.
+type bitReader struct {
+	r     io.ByteReader
+	cache uint64
+	n     uint
+}
+
+func newBitReader(r io.ByteReader) *bitReader {
+	return &bitReader{r: r}
+}
+
+func (b *bitReader) fill(n uint) error {
+	for b.n < n {
+		c, err := b.r.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				return io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		b.cache = b.cache<<8 | uint64(c)
+		b.n += 8
+	}
+	return nil
+}
+
+// readBits returns the next n bits, with n at most 56.
+func (b *bitReader) readBits(n uint) (uint64, error) {
+	if n == 0 {
+		return 0, nil
+	}
+	if err := b.fill(n); err != nil {
+		return 0, err
+	}
+	b.n -= n
+	return (b.cache >> b.n) & (1<<n - 1), nil
+}
+
+// readSigned returns the next n bits as a two's complement integer.
+func (b *bitReader) readSigned(n uint) (int64, error) {
+	v, err := b.readBits(n)
+	if err != nil || n == 0 {
+		return 0, err
+	}
+	return int64(v<<(64-n)) >> (64 - n), nil
+}
+
+func (b *bitReader) readBool() (bool, error) {
+	v, err := b.readBits(1)
+	return v == 1, err
+}
+
+// readUnary counts zero bits up to and including the terminating one bit.
+func (b *bitReader) readUnary() (uint64, error) {
+	var zeros uint64
+	for {
+		if b.n == 0 {
+			if err := b.fill(8); err != nil {
+				return 0, err
+			}
+		}
+		window := b.cache << (64 - b.n)
+		if window != 0 {
+			lz := uint(bits.LeadingZeros64(window))
+			zeros += uint64(lz)
+			b.n -= lz + 1
+			return zeros, nil
+		}
+		zeros += uint64(b.n)
+		b.n = 0
+	}
+}
+
+// align discards bits up to the next byte boundary.
+func (b *bitReader) align() {
+	b.n -= b.n % 8
+}
@@ -0,0 +1,148 @@
+package goshazam
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ErrInvalidAudioBuffer is returned for an AudioBuffer whose format does not describe its samples.
+var ErrInvalidAudioBuffer = errors.New("invalid audio buffer")
+
+// AudioBuffer is interleaved signed 16-bit audio together with the format needed to
+// interpret it. Unlike a bare []int16, it cannot be fingerprinted at the wrong rate:
+// MakeSignatureFromAudio and RecognizeAudio convert it to 16 kHz mono first.
+type AudioBuffer struct {
+	Samples    []int16
+	SampleRate int
+	Channels   int
+	// Offset is the position of the first sample in the source, e.g. the decode offset.
+	Offset time.Duration
+}
+
+// Validate checks that the format is usable and that Samples holds whole frames.
+func (b AudioBuffer) Validate() error {
+	switch {
+	case b.SampleRate <= 0:
+		return fmt.Errorf("%w: sample rate %d", ErrInvalidAudioBuffer, b.SampleRate)
+	case b.Channels <= 0:
+		return fmt.Errorf("%w: %d channels", ErrInvalidAudioBuffer, b.Channels)
+	case len(b.Samples)%b.Channels != 0:
+		return fmt.Errorf("%w: %d samples do not fill whole frames of %d channels", ErrInvalidAudioBuffer, len(b.Samples), b.Channels)
+	}
+	return nil
+}
+
+// Frames returns the number of samples per channel.
+func (b AudioBuffer) Frames() int {
+	if b.Channels <= 0 {
+		return 0
+	}
+	return len(b.Samples) / b.Channels
+}
+
+// Duration returns how long the buffer plays.
+func (b AudioBuffer) Duration() time.Duration {
+	if b.SampleRate <= 0 {
+		return 0
+	}
+	return time.Duration(b.Frames()) * time.Second / time.Duration(b.SampleRate)
+}
+
+// IsSignatureFormat reports whether the buffer is 16 kHz mono, the format the signature generator analyses.
+func (b AudioBuffer) IsSignatureFormat() bool {
+	return b.SampleRate == sampleRate && b.Channels == 1
+}
+
+// Slice returns the part of the buffer between from and to, measured from its start;
+// a zero or out-of-range to extends to the end. The result shares the samples and
+// its Offset is moved along.
+func (b AudioBuffer) Slice(from, to time.Duration) AudioBuffer {
+	frames := int64(b.Frames())
+	start := min(max(int64(from.Seconds()*float64(b.SampleRate)), 0), frames)
+	end := frames
+	if to > 0 {
+		end = min(max(int64(to.Seconds()*float64(b.SampleRate)), start), frames)
+	}
+	b.Offset += time.Duration(start) * time.Second / time.Duration(max(b.SampleRate, 1))
+	b.Samples = b.Samples[start*int64(b.Channels) : end*int64(b.Channels)]
+	return b
+}
+
+// Downmix returns the buffer averaged to mono.
+func (b AudioBuffer) Downmix() (AudioBuffer, error) {
+	if err := b.Validate(); err != nil {
+		return AudioBuffer{}, err
+	}
+	if b.Channels == 1 {
+		return b, nil
+	}
+	b.Samples = floatToInt16(Downmix(int16ToFloat(b.Samples), b.Channels))
+	b.Channels = 1
+	return b, nil
+}
+
+// Resample returns the buffer converted to rate Hz, each channel separately.
+func (b AudioBuffer) Resample(rate int) (AudioBuffer, error) {
+	if err := b.Validate(); err != nil {
+		return AudioBuffer{}, err
+	}
+	if b.SampleRate == rate {
+		return b, nil
+	}
+	r, err := NewResampler(b.SampleRate, rate)
+	if err != nil {
+		return AudioBuffer{}, err
+	}
+	samples := int16ToFloat(b.Samples)
+	channel := make([]float64, b.Frames())
+	var out []int16
+	for c := 0; c < b.Channels; c++ {
+		for i := range channel {
+			channel[i] = samples[i*b.Channels+c]
+		}
+		converted := floatToInt16(r.Resample(channel))
+		if out == nil {
+			out = make([]int16, len(converted)*b.Channels)
+		}
+		for i, v := range converted {
+			out[i*b.Channels+c] = v
+		}
+	}
+	b.Samples, b.SampleRate = out, rate
+	return b, nil
+}
+
+// ForSignature returns the buffer as 16 kHz mono, converting it only when needed.
+func (b AudioBuffer) ForSignature() (AudioBuffer, error) {
+	if err := b.Validate(); err != nil {
+		return AudioBuffer{}, err
+	}
+	if b.IsSignatureFormat() {
+		return b, nil
+	}
+	// Downmix first so that only one channel is resampled, like ConvertPCM.
+	samples, err := ConvertInt16PCM(b.Samples, b.SampleRate, b.Channels)
+	if err != nil {
+		return AudioBuffer{}, err
+	}
+	return AudioBuffer{Samples: samples, SampleRate: sampleRate, Channels: 1, Offset: b.Offset}, nil
+}
+
+// ReadAudioBuffer reads headerless PCM from r, e.g. a *bytes.Buffer returned by
+// GenerateRawPCMInMemory, into a buffer of the same rate and channel layout.
+func ReadAudioBuffer(r io.Reader, format PCMFormat) (AudioBuffer, error) {
+	if err := format.validate(); err != nil {
+		return AudioBuffer{}, err
+	}
+	samples, err := readPCM(r, format)
+	if err != nil {
+		return AudioBuffer{}, err
+	}
+	return AudioBuffer{
+		Samples:    floatToInt16(samples),
+		SampleRate: format.SampleRate,
+		Channels:   format.Channels,
+	}, nil
+}
@@ -33,12 +33,14 @@ type Signature struct {
 
 func GetSignatureJSON(signature *DecodedSignature) (*Signature, error) {
 	timestampMs := uint32(time.Now().UnixNano() / int64(time.Millisecond))
-	samples := uint32(float32(signature.NumberSamples) / float32(signature.SampleRateHz) * 1000)
 
 	uri, err := signature.EncodeToURI()
 	if err != nil {
 		return nil, fmt.Errorf("failed to encode signature to URI: %w", err)
 	}
+	// The length in milliseconds of whatever window the signature covers; the
+	// encoder has already rejected unsupported sample rates.
+	samples := uint32(uint64(signature.NumberSamples) * 1000 / uint64(signature.SampleRateHz))
 
 	return &Signature{
 		Geolocation: GeolocationResponse{
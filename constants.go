@@ -4,10 +4,10 @@ import "time"
 
 const (
 	sampleRate       = 16000
-	maxTimeSeconds   = 6
 	fftSize          = 2048
 	fftOutputSize    = fftSize/2 + 1
 	numFFTs          = 256
+	hopSize          = 128
 	minPeakMagnitude = 1.0 / 64.0
 )
 
@@ -28,6 +28,15 @@ const (
 	timeout = 30 * time.Second
 )
 
+const (
+	// DefaultSignatureWindow is how much audio a signature covers unless configured otherwise.
+	DefaultSignatureWindow = 6 * time.Second
+	// MinSignatureWindow and MaxSignatureWindow bound the configurable window. Shorter
+	// windows hold too few peaks to match; the Shazam app itself sends at most 12 seconds.
+	MinSignatureWindow = time.Second
+	MaxSignatureWindow = 12 * time.Second
+)
+
 const DISCOVERY_URL = "https://amp.shazam.com/discovery/v5/%s/%s/%s/-/tag/%s/%s?sync=true&webv3=true&sampling=true&connected=&shazamapiversion=v3&sharehub=true&hubv5minorversion=v5.1&hidelb=true&video=v3"
 
 var userAgents = [...]string{
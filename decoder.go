@@ -0,0 +1,427 @@
+package goshazam
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sniffLen is the number of leading bytes handed to Format.Match.
+const sniffLen = 512
+
+// ErrUnknownFormat is returned when no registered format matches the input and there is no fallback decoder.
+var ErrUnknownFormat = errors.New("unknown audio format")
+
+// ErrLiveUnsupported is returned when no decoder can decode the input while it arrives.
+var ErrLiveUnsupported = errors.New("live decoding not supported for this format")
+
+// ErrStreamNotFound is returned when the selected audio stream does not exist in the input.
+var ErrStreamNotFound = errors.New("audio stream not found")
+
+// DecodeOptions selects the part of the input that gets decoded.
+// A zero Duration decodes until the end of the input.
+type DecodeOptions struct {
+	Offset   time.Duration
+	Duration time.Duration
+	// stream is the selected AudioStream.AudioIndex plus one, so that zero keeps the decoder's default.
+	stream int
+}
+
+// WithStream returns a copy of o that decodes the audio stream with the given AudioStream.AudioIndex.
+func (o DecodeOptions) WithStream(index int) DecodeOptions {
+	o.stream = index + 1
+	return o
+}
+
+// Stream returns the selected audio stream index, or false when the decoder should pick its default stream.
+func (o DecodeOptions) Stream() (int, bool) {
+	return o.stream - 1, o.stream > 0
+}
+
+// checkSingleStream fails when a stream other than the first is selected from single-stream input.
+func (o DecodeOptions) checkSingleStream() error {
+	if index, ok := o.Stream(); ok && index != 0 {
+		return fmt.Errorf("%w: input has a single audio stream, stream %d requested", ErrStreamNotFound, index)
+	}
+	return nil
+}
+
+// frames converts the window to a start frame and a frame count at the given rate.
+// A zero count means the window is open-ended.
+func (o DecodeOptions) frames(rate int) (start, count int64) {
+	return int64(o.Offset.Seconds() * float64(rate)), int64(o.Duration.Seconds() * float64(rate))
+}
+
+// Decoder turns encoded audio into the 16 kHz mono samples expected by
+// SignatureGenerator.MakeSignatureFromBuffer.
+type Decoder interface {
+	Decode(ctx context.Context, r io.Reader, opts DecodeOptions) ([]int16, error)
+}
+
+// FileDecoder is implemented by decoders that prefer to open files themselves,
+// for example to let an external tool seek in them.
+type FileDecoder interface {
+	DecodeFile(ctx context.Context, path string, opts DecodeOptions) ([]int16, error)
+}
+
+// LiveDecoder is implemented by decoders that can decode unbounded input, such as
+// an internet radio stream, while it arrives. The returned reader yields signed 16-bit
+// little-endian 16 kHz mono PCM, the input of NewSampleReader, until r ends; closing it
+// releases the decoder but not r.
+type LiveDecoder interface {
+	DecodeLive(ctx context.Context, r io.Reader) (io.ReadCloser, error)
+}
+
+// StreamDecoder is implemented by decoders that can hand over the selected window while
+// they decode it, so that memory use does not grow with the window's length. The returned
+// reader yields signed 16-bit little-endian 16 kHz mono PCM, the input of NewSampleReader;
+// decoding failures are returned by Read. Closing it releases the decoder but not r.
+type StreamDecoder interface {
+	DecodeStream(ctx context.Context, r io.Reader, opts DecodeOptions) (io.ReadCloser, error)
+}
+
+// readDecoded returns all samples of the PCM that a StreamDecoder yields and closes it.
+func readDecoded(pcm io.ReadCloser) ([]int16, error) {
+	defer pcm.Close()
+	samples, err := NewSampleReader(pcm, ffmpegReadChunk).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	return samples, nil
+}
+
+// decodedPCM encodes samples as signed 16-bit little-endian PCM, for decoders that
+// are not StreamDecoders.
+func decodedPCM(samples []int16) io.ReadCloser {
+	data := make([]byte, 0, 2*len(samples))
+	for _, v := range samples {
+		data = binary.LittleEndian.AppendUint16(data, uint16(v))
+	}
+	return io.NopCloser(bytes.NewReader(data))
+}
+
+// AudioStream describes one audio stream of a container.
+type AudioStream struct {
+	// Index is the stream's position among all streams of the container.
+	Index int
+	// AudioIndex is the stream's position among the audio streams only; it is what DecodeOptions.WithStream takes.
+	AudioIndex int
+	Codec      string
+	SampleRate int
+	Channels   int
+	Language   string
+	Title      string
+	Default    bool
+}
+
+// StreamLister is implemented by decoders that can enumerate the audio streams of a file.
+// Decoders without it are assumed to handle single-stream formats.
+type StreamLister interface {
+	AudioStreams(ctx context.Context, path string) ([]AudioStream, error)
+}
+
+// Format ties a Decoder to the inputs it handles.
+type Format struct {
+	Name string
+	// Extensions are matched case-insensitively against file names, e.g. ".wav".
+	Extensions []string
+	// Match reports whether the leading bytes of the input belong to this format.
+	Match   func(header []byte) bool
+	Decoder Decoder
+}
+
+// DecoderSet picks a Decoder for each input, first by sniffing its leading
+// bytes, then by file extension, and finally falling back to a default decoder.
+// Formats registered later take precedence over earlier ones.
+type DecoderSet struct {
+	mu       sync.RWMutex
+	formats  []Format
+	fallback Decoder
+}
+
+// NewDecoderSet returns an empty set that uses fallback for unrecognized input.
+// A nil fallback makes unrecognized input fail with ErrUnknownFormat.
+func NewDecoderSet(fallback Decoder) *DecoderSet {
+	return &DecoderSet{fallback: fallback}
+}
+
+// DefaultDecoderSet returns the built-in native decoders with ffmpeg as the fallback.
+func DefaultDecoderSet() *DecoderSet {
+	return newDefaultDecoderSet(defaultFFmpegPath)
+}
+
+func newDefaultDecoderSet(ffmpegPath string) *DecoderSet {
+	s := NewDecoderSet(&FFmpegDecoder{Path: ffmpegPath})
+	s.Register(Format{Name: "wav", Extensions: []string{".wav", ".wave"}, Match: IsWAV, Decoder: WAVDecoder{}})
+	s.Register(Format{Name: "flac", Extensions: []string{".flac"}, Match: IsFLAC, Decoder: FLACDecoder{}})
+	s.Register(Format{Name: "mp3", Extensions: []string{".mp3"}, Match: IsMP3, Decoder: MP3Decoder{}})
+	// Ogg also carries Opus and FLAC, so Vorbis is recognized by its header only and never by extension.
+	s.Register(Format{Name: "vorbis", Match: IsOggVorbis, Decoder: VorbisDecoder{}})
+	return s
+}
+
+// Register adds a format to the set.
+func (s *DecoderSet) Register(f Format) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.formats = append(s.formats, f)
+}
+
+// Lookup returns the decoder for an input with the given leading bytes and
+// file name; name may be empty when it is unknown.
+func (s *DecoderSet) Lookup(header []byte, name string) (Decoder, error) {
+	f, err := s.lookupFormat(header, name)
+	if err != nil {
+		return nil, err
+	}
+	return f.Decoder, nil
+}
+
+// lookupFormat is Lookup returning the whole Format; the fallback decoder has an empty Name.
+func (s *DecoderSet) lookupFormat(header []byte, name string) (Format, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for i := len(s.formats) - 1; i >= 0; i-- {
+		if match := s.formats[i].Match; match != nil && match(header) {
+			return s.formats[i], nil
+		}
+	}
+	if ext := strings.ToLower(filepath.Ext(name)); ext != "" {
+		for i := len(s.formats) - 1; i >= 0; i-- {
+			for _, e := range s.formats[i].Extensions {
+				if strings.ToLower(e) == ext {
+					return s.formats[i], nil
+				}
+			}
+		}
+	}
+	if s.fallback == nil {
+		return Format{}, ErrUnknownFormat
+	}
+	return Format{Decoder: s.fallback}, nil
+}
+
+// lookupFile sniffs the file at path and returns its format.
+func (s *DecoderSet) lookupFile(path string) (Format, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Format{}, err
+	}
+	defer f.Close()
+
+	header := make([]byte, sniffLen)
+	n, err := io.ReadFull(f, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return Format{}, fmt.Errorf("failed to read header: %w", err)
+	}
+	return s.lookupFormat(header[:n], path)
+}
+
+// AudioStreams lists the audio streams of the file at path. Formats whose
+// decoder is not a StreamLister report a single stream.
+func (s *DecoderSet) AudioStreams(ctx context.Context, path string) ([]AudioStream, error) {
+	f, err := s.lookupFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if lister, ok := f.Decoder.(StreamLister); ok {
+		return lister.AudioStreams(ctx, path)
+	}
+	return []AudioStream{{Codec: f.Name, Default: true}}, nil
+}
+
+// DecodeFile decodes the selected window of the audio file at path.
+func (s *DecoderSet) DecodeFile(ctx context.Context, path string, opts DecodeOptions) ([]int16, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	d, err := s.lookupOpenFile(f, path)
+	if err != nil {
+		return nil, err
+	}
+	if fd, ok := d.(FileDecoder); ok {
+		return fd.DecodeFile(ctx, path, opts)
+	}
+	return d.Decode(ctx, f, opts)
+}
+
+// decodeFileStream is DecodeFile handing over the samples as signed 16-bit little-endian
+// PCM while a StreamDecoder decodes them. The samples of other decoders are decoded in
+// full first. Closing the returned reader closes the file.
+func (s *DecoderSet) decodeFileStream(ctx context.Context, path string, opts DecodeOptions) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	d, err := s.lookupOpenFile(f, path)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	var pcm io.ReadCloser
+	switch d := d.(type) {
+	case fileStreamDecoder:
+		pcm, err = d.decodeFileStream(ctx, path, opts)
+	case StreamDecoder:
+		pcm, err = d.DecodeStream(ctx, f, opts)
+	case FileDecoder:
+		var samples []int16
+		samples, err = d.DecodeFile(ctx, path, opts)
+		pcm = decodedPCM(samples)
+	default:
+		var samples []int16
+		samples, err = d.Decode(ctx, f, opts)
+		pcm = decodedPCM(samples)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &closeBoth{ReadCloser: pcm, source: f}, nil
+}
+
+// fileStreamDecoder is implemented by FileDecoders that are StreamDecoders for files too.
+type fileStreamDecoder interface {
+	decodeFileStream(ctx context.Context, path string, opts DecodeOptions) (io.ReadCloser, error)
+}
+
+// closeBoth closes the input that a decoded stream reads along with the stream.
+type closeBoth struct {
+	io.ReadCloser
+	source io.Closer
+}
+
+func (c *closeBoth) Close() error {
+	err := c.ReadCloser.Close()
+	if sourceErr := c.source.Close(); err == nil {
+		err = sourceErr
+	}
+	return err
+}
+
+// lookupOpenFile sniffs f, opened from path, and rewinds it.
+func (s *DecoderSet) lookupOpenFile(f *os.File, path string) (Decoder, error) {
+	header := make([]byte, sniffLen)
+	n, err := io.ReadFull(f, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+	d, err := s.Lookup(header[:n], path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// DecodeReader decodes the selected window of the audio read from r. When r is
+// an io.ReadSeeker, decoders may seek in it to skip audio before the offset.
+func (s *DecoderSet) DecodeReader(ctx context.Context, r io.Reader, opts DecodeOptions) ([]int16, error) {
+	return s.decodeReader(ctx, r, "", opts)
+}
+
+// decodeReader is DecodeReader with a file name to fall back on when sniffing fails.
+func (s *DecoderSet) decodeReader(ctx context.Context, r io.Reader, name string, opts DecodeOptions) ([]int16, error) {
+	d, r, err := s.lookupReader(r, name)
+	if err != nil {
+		return nil, err
+	}
+	return d.Decode(ctx, r, opts)
+}
+
+// decodeReaderStream is decodeReader handing over the samples as signed 16-bit little-endian
+// PCM while a StreamDecoder decodes them. The samples of other decoders are decoded in full first.
+func (s *DecoderSet) decodeReaderStream(ctx context.Context, r io.Reader, name string, opts DecodeOptions) (io.ReadCloser, error) {
+	d, r, err := s.lookupReader(r, name)
+	if err != nil {
+		return nil, err
+	}
+	if sd, ok := d.(StreamDecoder); ok {
+		return sd.DecodeStream(ctx, r, opts)
+	}
+	samples, err := d.Decode(ctx, r, opts)
+	if err != nil {
+		return nil, err
+	}
+	return decodedPCM(samples), nil
+}
+
+// lookupReader sniffs r and returns its decoder along with a reader that starts where r did.
+func (s *DecoderSet) lookupReader(r io.Reader, name string) (Decoder, io.Reader, error) {
+	if rs, ok := r.(io.ReadSeeker); ok {
+		start, err := rs.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, nil, err
+		}
+		header := make([]byte, sniffLen)
+		n, err := io.ReadFull(rs, header)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+			return nil, nil, fmt.Errorf("failed to read header: %w", err)
+		}
+		d, err := s.Lookup(header[:n], name)
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, err := rs.Seek(start, io.SeekStart); err != nil {
+			return nil, nil, err
+		}
+		return d, rs, nil
+	}
+
+	br := bufio.NewReaderSize(r, sniffLen)
+	header, _ := br.Peek(sniffLen)
+	d, err := s.Lookup(header, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	return d, br, nil
+}
+
+// DecodeLive starts decoding unbounded input read from r; name may be empty when it is
+// unknown. When the matched decoder is not a LiveDecoder, the fallback decoder is used
+// if it is one, and ErrLiveUnsupported is returned otherwise.
+func (s *DecoderSet) DecodeLive(ctx context.Context, r io.Reader, name string) (io.ReadCloser, error) {
+	br := bufio.NewReaderSize(r, sniffLen)
+	header, _ := br.Peek(sniffLen)
+	d, err := s.Lookup(header, name)
+	if err != nil {
+		return nil, err
+	}
+	live, ok := d.(LiveDecoder)
+	if !ok {
+		s.mu.RLock()
+		live, ok = s.fallback.(LiveDecoder)
+		s.mu.RUnlock()
+	}
+	if !ok {
+		return nil, ErrLiveUnsupported
+	}
+	return live.DecodeLive(ctx, br)
+}
+
+// DecodeFile returns 16 kHz mono samples for the selected window of the audio
+// file at path using DefaultDecoderSet.
+func DecodeFile(ctx context.Context, path string, opts DecodeOptions) ([]int16, error) {
+	return DefaultDecoderSet().DecodeFile(ctx, path, opts)
+}
+
+// DecodeReader returns 16 kHz mono samples for the selected window of the
+// audio read from r using DefaultDecoderSet.
+func DecodeReader(ctx context.Context, r io.Reader, opts DecodeOptions) ([]int16, error) {
+	return DefaultDecoderSet().DecodeReader(ctx, r, opts)
+}
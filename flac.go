@@ -0,0 +1,819 @@
+package goshazam
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	flacBlockStreamInfo = 0
+	flacBlockSeekTable  = 3
+	flacBlockComment    = 4
+	flacSyncCode        = 0x3FFE
+	flacPlaceholderSeek = 0xFFFFFFFFFFFFFFFF
+)
+
+// ErrInvalidFLAC is returned when the input is not a well-formed FLAC stream.
+var ErrInvalidFLAC = errors.New("invalid FLAC data")
+
+var (
+	flacCRC8Table  = makeCRC8Table(0x07)
+	flacCRC16Table = makeCRC16Table(0x8005)
+)
+
+// FLACStreamInfo holds the fields of the STREAMINFO metadata block.
+type FLACStreamInfo struct {
+	MinBlockSize  uint16
+	MaxBlockSize  uint16
+	SampleRate    uint32
+	Channels      uint8
+	BitsPerSample uint8
+	TotalSamples  uint64
+	MD5           [16]byte
+}
+
+type flacSeekPoint struct {
+	sampleNumber uint64
+	offset       uint64
+}
+
+// FLACDecoder is the native Decoder for FLAC streams.
+type FLACDecoder struct{}
+
+func (FLACDecoder) Decode(_ context.Context, r io.Reader, opts DecodeOptions) ([]int16, error) {
+	return DecodeFLAC(r, opts)
+}
+
+// DecodeStream decodes the selected window of r a frame at a time.
+func (FLACDecoder) DecodeStream(_ context.Context, r io.Reader, opts DecodeOptions) (io.ReadCloser, error) {
+	return decodeFLACStream(r, opts)
+}
+
+func (FLACDecoder) Probe(_ context.Context, path string) (*MediaInfo, error) {
+	return probeFile(path, probeFLAC)
+}
+
+// probeFLAC reads the metadata blocks. The bitrate is the average over the frames.
+func probeFLAC(r io.ReadSeeker, size int64) (*MediaInfo, error) {
+	id3Tags, id3Size, err := readID3v2(r)
+	if err != nil {
+		return nil, err
+	}
+	d, err := newFLACReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := parseVorbisComments(d.comments)
+	for key, value := range id3Tags {
+		if _, ok := tags[key]; !ok {
+			tags[key] = value
+		}
+	}
+	duration := framesDuration(int64(d.info.TotalSamples), int(d.info.SampleRate))
+	return &MediaInfo{
+		Duration:   duration,
+		Container:  "flac",
+		Codec:      "flac",
+		SampleRate: int(d.info.SampleRate),
+		Channels:   int(d.info.Channels),
+		Bitrate:    averageBitrate(size-id3Size-d.firstFrame, duration),
+		Tags:       tags,
+	}, nil
+}
+
+// IsFLAC reports whether header starts with the FLAC stream marker, optionally
+// preceded by an ID3v2 tag.
+func IsFLAC(header []byte) bool {
+	return bytes.HasPrefix(header[skipID3v2Header(header):], []byte("fLaC"))
+}
+
+// DecodeFLAC decodes the selected window of a FLAC stream into 16 kHz mono
+// samples. When r is an io.Seeker and the stream has a SEEKTABLE, frames
+// before the offset are skipped without being decoded.
+func DecodeFLAC(r io.Reader, opts DecodeOptions) ([]int16, error) {
+	pcm, err := decodeFLACStream(r, opts)
+	if err != nil {
+		return nil, err
+	}
+	return readDecoded(pcm)
+}
+
+func decodeFLACStream(r io.Reader, opts DecodeOptions) (*pcmStream, error) {
+	if err := opts.checkSingleStream(); err != nil {
+		return nil, err
+	}
+	d, err := newFLACReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	rate := int(d.info.SampleRate)
+	channels := int(d.info.Channels)
+	startFrame, frameCount := opts.frames(rate)
+	if err := d.seek(uint64(startFrame)); err != nil {
+		return nil, err
+	}
+
+	scale := 1 / float64(int64(1)<<(d.info.BitsPerSample-1))
+	var out []float64
+	var decoded int64
+	return newPCMStream(func() ([]float64, error) {
+		for frameCount == 0 || decoded < frameCount {
+			block, first, err := d.readFrame()
+			// Keep whatever was decoded from a truncated file, as ffmpeg does.
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			blockSize := uint64(len(block[0]))
+			if first+blockSize <= uint64(startFrame) {
+				continue
+			}
+			from := uint64(0)
+			if first < uint64(startFrame) {
+				from = uint64(startFrame) - first
+			}
+			to := blockSize
+			if frameCount > 0 {
+				to = min(to, from+uint64(frameCount-decoded))
+			}
+			out = out[:0]
+			for i := from; i < to; i++ {
+				for c := 0; c < channels; c++ {
+					out = append(out, float64(block[c][i])*scale)
+				}
+			}
+			decoded += int64(to - from)
+			return out, nil
+		}
+		return nil, io.EOF
+	}, rate, channels)
+}
+
+// flacReader walks the frames of a FLAC stream.
+type flacReader struct {
+	src        io.Reader
+	br         *bufio.Reader
+	bytes      *crcByteReader
+	bits       *bitReader
+	info       FLACStreamInfo
+	seekTable  []flacSeekPoint
+	comments   []string
+	base       int64
+	firstFrame int64
+	samples    [][]int64
+}
+
+func newFLACReader(r io.Reader) (*flacReader, error) {
+	d := &flacReader{src: r, br: bufio.NewReader(r)}
+	d.bytes = &crcByteReader{r: d.br}
+	d.bits = newBitReader(d.bytes)
+	if seeker, ok := r.(io.Seeker); ok {
+		d.base, _ = seeker.Seek(0, io.SeekCurrent)
+	}
+
+	if err := d.skipID3v2(); err != nil {
+		return nil, err
+	}
+	marker := make([]byte, 4)
+	if _, err := io.ReadFull(d.bytes, marker); err != nil {
+		return nil, fmt.Errorf("failed to read FLAC marker: %w", err)
+	}
+	if string(marker) != "fLaC" {
+		return nil, ErrInvalidFLAC
+	}
+	if err := d.readMetadata(); err != nil {
+		return nil, err
+	}
+	d.firstFrame = d.bytes.count
+	return d, nil
+}
+
+func (d *flacReader) skipID3v2() error {
+	header, _ := d.br.Peek(10)
+	n := skipID3v2Header(header)
+	if n == 0 {
+		return nil
+	}
+	if _, err := io.CopyN(io.Discard, d.bytes, int64(n)); err != nil {
+		return fmt.Errorf("failed to skip ID3v2 tag: %w", err)
+	}
+	return nil
+}
+
+func (d *flacReader) readMetadata() error {
+	haveInfo := false
+	for {
+		header := make([]byte, 4)
+		if _, err := io.ReadFull(d.bytes, header); err != nil {
+			return fmt.Errorf("failed to read FLAC metadata block header: %w", err)
+		}
+		isLast := header[0]&0x80 != 0
+		blockType := header[0] & 0x7F
+		length := int(header[1])<<16 | int(header[2])<<8 | int(header[3])
+		block := make([]byte, length)
+		if _, err := io.ReadFull(d.bytes, block); err != nil {
+			return fmt.Errorf("failed to read FLAC metadata block: %w", err)
+		}
+
+		switch blockType {
+		case flacBlockStreamInfo:
+			if length < 34 {
+				return fmt.Errorf("%w: STREAMINFO too short", ErrInvalidFLAC)
+			}
+			d.info = parseFLACStreamInfo(block)
+			haveInfo = true
+		case flacBlockSeekTable:
+			for i := 0; i+18 <= length; i += 18 {
+				point := flacSeekPoint{
+					sampleNumber: binary.BigEndian.Uint64(block[i:]),
+					offset:       binary.BigEndian.Uint64(block[i+8:]),
+				}
+				if point.sampleNumber != flacPlaceholderSeek {
+					d.seekTable = append(d.seekTable, point)
+				}
+			}
+		case flacBlockComment:
+			// Tags are optional, so a malformed comment block is ignored rather than failing the decode.
+			d.comments, _ = parseFLACComments(block)
+		}
+		if isLast {
+			break
+		}
+	}
+	if !haveInfo {
+		return fmt.Errorf("%w: missing STREAMINFO", ErrInvalidFLAC)
+	}
+	if d.info.SampleRate == 0 || d.info.BitsPerSample < 4 || d.info.BitsPerSample > 32 {
+		return fmt.Errorf("%w: unsupported STREAMINFO (%d Hz, %d bits)", ErrInvalidFLAC, d.info.SampleRate, d.info.BitsPerSample)
+	}
+	return nil
+}
+
+// parseFLACComments parses a VORBIS_COMMENT block, which unlike the rest of FLAC is little-endian.
+func parseFLACComments(b []byte) ([]string, error) {
+	readString := func() (string, bool) {
+		if len(b) < 4 {
+			return "", false
+		}
+		n := binary.LittleEndian.Uint32(b)
+		if uint64(n) > uint64(len(b)-4) {
+			return "", false
+		}
+		str := string(b[4 : 4+n])
+		b = b[4+n:]
+		return str, true
+	}
+	if _, ok := readString(); !ok { // vendor string
+		return nil, fmt.Errorf("%w: truncated comment block", ErrInvalidFLAC)
+	}
+	if len(b) < 4 {
+		return nil, fmt.Errorf("%w: truncated comment block", ErrInvalidFLAC)
+	}
+	count := binary.LittleEndian.Uint32(b)
+	b = b[4:]
+	var comments []string
+	for i := uint32(0); i < count; i++ {
+		comment, ok := readString()
+		if !ok {
+			return comments, fmt.Errorf("%w: truncated comment block", ErrInvalidFLAC)
+		}
+		comments = append(comments, comment)
+	}
+	return comments, nil
+}
+
+func parseFLACStreamInfo(b []byte) FLACStreamInfo {
+	info := FLACStreamInfo{
+		MinBlockSize: binary.BigEndian.Uint16(b[0:2]),
+		MaxBlockSize: binary.BigEndian.Uint16(b[2:4]),
+	}
+	packed := binary.BigEndian.Uint64(b[10:18])
+	info.SampleRate = uint32(packed >> 44)
+	info.Channels = uint8(packed>>41&0x7) + 1
+	info.BitsPerSample = uint8(packed>>36&0x1F) + 1
+	info.TotalSamples = packed & (1<<36 - 1)
+	copy(info.MD5[:], b[18:34])
+	return info
+}
+
+// seek moves to the last seek point at or before sample when the source
+// supports seeking; otherwise frames are decoded and discarded by the caller.
+func (d *flacReader) seek(sample uint64) error {
+	seeker, ok := d.src.(io.Seeker)
+	if !ok || sample == 0 {
+		return nil
+	}
+	var best *flacSeekPoint
+	for i := range d.seekTable {
+		if d.seekTable[i].sampleNumber <= sample {
+			best = &d.seekTable[i]
+		}
+	}
+	if best == nil || best.offset == 0 {
+		return nil
+	}
+	// The bufio.Reader has consumed ahead of the logical position, so seek absolutely.
+	target := d.base + d.firstFrame + int64(best.offset)
+	if _, err := seeker.Seek(target, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek FLAC stream: %w", err)
+	}
+	d.br.Reset(d.src)
+	d.bytes.count = d.firstFrame + int64(best.offset)
+	return nil
+}
+
+// readFrame decodes the next frame and returns its samples per channel along
+// with the number of the first sample in the frame.
+func (d *flacReader) readFrame() ([][]int64, uint64, error) {
+	start := d.bytes.count
+	d.bytes.crc8, d.bytes.crc16 = 0, 0
+	sync, err := d.bits.readBits(14)
+	if err != nil {
+		if d.bytes.count == start {
+			return nil, 0, io.EOF
+		}
+		return nil, 0, err
+	}
+	if sync != flacSyncCode {
+		return nil, 0, fmt.Errorf("%w: lost frame sync", ErrInvalidFLAC)
+	}
+
+	fields, err := d.bits.readBits(18)
+	if err != nil {
+		return nil, 0, err
+	}
+	variableBlockSize := fields>>16&1 == 1
+	blockSizeCode := fields >> 12 & 0xF
+	sampleRateCode := fields >> 8 & 0xF
+	channelAssignment := fields >> 4 & 0xF
+	sampleSizeCode := fields >> 1 & 0x7
+
+	number, err := d.readUTF8Number()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	blockSize, err := d.blockSize(blockSizeCode)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := d.skipSampleRate(sampleRateCode); err != nil {
+		return nil, 0, err
+	}
+	bps, err := d.sampleSize(sampleSizeCode)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	expectedCRC8 := d.bytes.crc8
+	headerCRC, err := d.bits.readBits(8)
+	if err != nil {
+		return nil, 0, err
+	}
+	if uint8(headerCRC) != expectedCRC8 {
+		return nil, 0, fmt.Errorf("%w: frame header CRC mismatch", ErrInvalidFLAC)
+	}
+
+	channels := int(channelAssignment) + 1
+	if channelAssignment >= 8 {
+		if channelAssignment > 10 {
+			return nil, 0, fmt.Errorf("%w: reserved channel assignment %d", ErrInvalidFLAC, channelAssignment)
+		}
+		channels = 2
+	}
+	if channels != int(d.info.Channels) {
+		return nil, 0, fmt.Errorf("%w: frame has %d channels, stream has %d", ErrInvalidFLAC, channels, d.info.Channels)
+	}
+
+	d.samples = resizeChannels(d.samples, channels, blockSize)
+	for c := 0; c < channels; c++ {
+		subframeBPS := bps
+		if (channelAssignment == 8 || channelAssignment == 10) && c == 1 ||
+			channelAssignment == 9 && c == 0 {
+			subframeBPS++
+		}
+		if err := d.readSubframe(d.samples[c], subframeBPS); err != nil {
+			return nil, 0, err
+		}
+	}
+	decorrelateFLACStereo(d.samples, channelAssignment)
+
+	d.bits.align()
+	expectedCRC16 := d.bytes.crc16
+	footerCRC, err := d.bits.readBits(16)
+	if err != nil {
+		return nil, 0, err
+	}
+	if uint16(footerCRC) != expectedCRC16 {
+		return nil, 0, fmt.Errorf("%w: frame CRC mismatch", ErrInvalidFLAC)
+	}
+
+	first := number
+	if !variableBlockSize {
+		first = number * uint64(d.info.MinBlockSize)
+		if d.info.MinBlockSize != d.info.MaxBlockSize {
+			first = number * uint64(blockSize)
+		}
+	}
+	return d.samples, first, nil
+}
+
+func (d *flacReader) readUTF8Number() (uint64, error) {
+	b, err := d.bits.readBits(8)
+	if err != nil {
+		return 0, err
+	}
+	var extra int
+	switch {
+	case b&0x80 == 0:
+		return b, nil
+	case b&0xE0 == 0xC0:
+		b, extra = b&0x1F, 1
+	case b&0xF0 == 0xE0:
+		b, extra = b&0x0F, 2
+	case b&0xF8 == 0xF0:
+		b, extra = b&0x07, 3
+	case b&0xFC == 0xF8:
+		b, extra = b&0x03, 4
+	case b&0xFE == 0xFC:
+		b, extra = b&0x01, 5
+	case b == 0xFE:
+		b, extra = 0, 6
+	default:
+		return 0, fmt.Errorf("%w: invalid frame number encoding", ErrInvalidFLAC)
+	}
+	for i := 0; i < extra; i++ {
+		c, err := d.bits.readBits(8)
+		if err != nil {
+			return 0, err
+		}
+		if c&0xC0 != 0x80 {
+			return 0, fmt.Errorf("%w: invalid frame number encoding", ErrInvalidFLAC)
+		}
+		b = b<<6 | c&0x3F
+	}
+	return b, nil
+}
+
+func (d *flacReader) blockSize(code uint64) (int, error) {
+	switch {
+	case code == 1:
+		return 192, nil
+	case code >= 2 && code <= 5:
+		return 576 << (code - 2), nil
+	case code == 6:
+		v, err := d.bits.readBits(8)
+		return int(v) + 1, err
+	case code == 7:
+		v, err := d.bits.readBits(16)
+		return int(v) + 1, err
+	case code >= 8:
+		return 256 << (code - 8), nil
+	default:
+		return 0, fmt.Errorf("%w: reserved block size", ErrInvalidFLAC)
+	}
+}
+
+// skipSampleRate consumes any explicit sample rate; the STREAMINFO rate is authoritative.
+func (d *flacReader) skipSampleRate(code uint64) error {
+	var err error
+	switch code {
+	case 12:
+		_, err = d.bits.readBits(8)
+	case 13, 14:
+		_, err = d.bits.readBits(16)
+	case 15:
+		err = fmt.Errorf("%w: invalid sample rate", ErrInvalidFLAC)
+	}
+	return err
+}
+
+func (d *flacReader) sampleSize(code uint64) (uint, error) {
+	switch code {
+	case 0:
+		return uint(d.info.BitsPerSample), nil
+	case 1:
+		return 8, nil
+	case 2:
+		return 12, nil
+	case 4:
+		return 16, nil
+	case 5:
+		return 20, nil
+	case 6:
+		return 24, nil
+	case 7:
+		return 32, nil
+	default:
+		return 0, fmt.Errorf("%w: reserved sample size", ErrInvalidFLAC)
+	}
+}
+
+func (d *flacReader) readSubframe(out []int64, bps uint) error {
+	header, err := d.bits.readBits(8)
+	if err != nil {
+		return err
+	}
+	if header&0x80 != 0 {
+		return fmt.Errorf("%w: subframe padding bit set", ErrInvalidFLAC)
+	}
+	subframeType := header >> 1 & 0x3F
+
+	var wasted uint
+	if header&1 == 1 {
+		k, err := d.bits.readUnary()
+		if err != nil {
+			return err
+		}
+		wasted = uint(k) + 1
+		if wasted >= bps {
+			return fmt.Errorf("%w: too many wasted bits", ErrInvalidFLAC)
+		}
+		bps -= wasted
+	}
+
+	switch {
+	case subframeType == 0:
+		v, err := d.bits.readSigned(bps)
+		if err != nil {
+			return err
+		}
+		for i := range out {
+			out[i] = v
+		}
+	case subframeType == 1:
+		for i := range out {
+			if out[i], err = d.bits.readSigned(bps); err != nil {
+				return err
+			}
+		}
+	case subframeType >= 8 && subframeType <= 12:
+		err = d.readFixedSubframe(out, bps, int(subframeType-8))
+	case subframeType >= 32:
+		err = d.readLPCSubframe(out, bps, int(subframeType-31))
+	default:
+		err = fmt.Errorf("%w: reserved subframe type %d", ErrInvalidFLAC, subframeType)
+	}
+	if err != nil {
+		return err
+	}
+
+	if wasted > 0 {
+		for i := range out {
+			out[i] <<= wasted
+		}
+	}
+	return nil
+}
+
+func (d *flacReader) readWarmup(out []int64, bps uint, order int) error {
+	if order > len(out) {
+		return fmt.Errorf("%w: predictor order exceeds block size", ErrInvalidFLAC)
+	}
+	for i := 0; i < order; i++ {
+		v, err := d.bits.readSigned(bps)
+		if err != nil {
+			return err
+		}
+		out[i] = v
+	}
+	return nil
+}
+
+func (d *flacReader) readFixedSubframe(out []int64, bps uint, order int) error {
+	if err := d.readWarmup(out, bps, order); err != nil {
+		return err
+	}
+	if err := d.readResidual(out, order); err != nil {
+		return err
+	}
+	for i := order; i < len(out); i++ {
+		switch order {
+		case 1:
+			out[i] += out[i-1]
+		case 2:
+			out[i] += 2*out[i-1] - out[i-2]
+		case 3:
+			out[i] += 3*out[i-1] - 3*out[i-2] + out[i-3]
+		case 4:
+			out[i] += 4*out[i-1] - 6*out[i-2] + 4*out[i-3] - out[i-4]
+		}
+	}
+	return nil
+}
+
+func (d *flacReader) readLPCSubframe(out []int64, bps uint, order int) error {
+	if err := d.readWarmup(out, bps, order); err != nil {
+		return err
+	}
+	precision, err := d.bits.readBits(4)
+	if err != nil {
+		return err
+	}
+	if precision == 0xF {
+		return fmt.Errorf("%w: invalid LPC precision", ErrInvalidFLAC)
+	}
+	shift, err := d.bits.readSigned(5)
+	if err != nil {
+		return err
+	}
+	if shift < 0 {
+		return fmt.Errorf("%w: negative LPC shift", ErrInvalidFLAC)
+	}
+	coefs := make([]int64, order)
+	for i := range coefs {
+		if coefs[i], err = d.bits.readSigned(uint(precision) + 1); err != nil {
+			return err
+		}
+	}
+	if err := d.readResidual(out, order); err != nil {
+		return err
+	}
+	for i := order; i < len(out); i++ {
+		var prediction int64
+		for j, c := range coefs {
+			prediction += c * out[i-1-j]
+		}
+		out[i] += prediction >> shift
+	}
+	return nil
+}
+
+// readResidual stores the Rice-coded residual in out[order:].
+func (d *flacReader) readResidual(out []int64, order int) error {
+	method, err := d.bits.readBits(2)
+	if err != nil {
+		return err
+	}
+	var paramBits uint
+	switch method {
+	case 0:
+		paramBits = 4
+	case 1:
+		paramBits = 5
+	default:
+		return fmt.Errorf("%w: reserved residual coding method", ErrInvalidFLAC)
+	}
+	escape := uint64(1)<<paramBits - 1
+
+	partitionOrder, err := d.bits.readBits(4)
+	if err != nil {
+		return err
+	}
+	partitions := 1 << partitionOrder
+	partitionSize := len(out) >> partitionOrder
+	if partitionSize<<partitionOrder != len(out) || partitionSize < order {
+		return fmt.Errorf("%w: invalid residual partition order", ErrInvalidFLAC)
+	}
+
+	i := order
+	for p := 0; p < partitions; p++ {
+		end := (p + 1) * partitionSize
+		param, err := d.bits.readBits(paramBits)
+		if err != nil {
+			return err
+		}
+		if param == escape {
+			n, err := d.bits.readBits(5)
+			if err != nil {
+				return err
+			}
+			for ; i < end; i++ {
+				if out[i], err = d.bits.readSigned(uint(n)); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		for ; i < end; i++ {
+			q, err := d.bits.readUnary()
+			if err != nil {
+				return err
+			}
+			r, err := d.bits.readBits(uint(param))
+			if err != nil {
+				return err
+			}
+			v := q<<param | r
+			out[i] = int64(v>>1) ^ -int64(v&1)
+		}
+	}
+	return nil
+}
+
+func decorrelateFLACStereo(samples [][]int64, channelAssignment uint64) {
+	switch channelAssignment {
+	case 8: // left/side
+		for i := range samples[0] {
+			samples[1][i] = samples[0][i] - samples[1][i]
+		}
+	case 9: // side/right
+		for i := range samples[0] {
+			samples[0][i] += samples[1][i]
+		}
+	case 10: // mid/side
+		for i := range samples[0] {
+			mid := samples[0][i]<<1 | samples[1][i]&1
+			side := samples[1][i]
+			samples[0][i] = (mid + side) >> 1
+			samples[1][i] = (mid - side) >> 1
+		}
+	}
+}
+
+func resizeChannels(buf [][]int64, channels, size int) [][]int64 {
+	if len(buf) != channels {
+		buf = make([][]int64, channels)
+	}
+	for c := range buf {
+		if cap(buf[c]) < size {
+			buf[c] = make([]int64, size)
+		}
+		buf[c] = buf[c][:size]
+	}
+	return buf
+}
+
+// skipID3v2Header returns the size of a leading ID3v2 tag, or 0 when there is none.
+func skipID3v2Header(header []byte) int {
+	if len(header) < 10 || string(header[:3]) != "ID3" {
+		return 0
+	}
+	size := int(header[6]&0x7F)<<21 | int(header[7]&0x7F)<<14 | int(header[8]&0x7F)<<7 | int(header[9]&0x7F)
+	size += 10
+	if header[5]&0x10 != 0 {
+		size += 10
+	}
+	return size
+}
+
+// crcByteReader tracks the stream position and the running FLAC CRCs of the bytes it hands out.
+type crcByteReader struct {
+	r     io.ByteReader
+	count int64
+	crc8  uint8
+	crc16 uint16
+}
+
+func (c *crcByteReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	c.count++
+	c.crc8 = flacCRC8Table[c.crc8^b]
+	c.crc16 = c.crc16<<8 ^ flacCRC16Table[byte(c.crc16>>8)^b]
+	return b, nil
+}
+
+func (c *crcByteReader) Read(p []byte) (int, error) {
+	for i := range p {
+		b, err := c.ReadByte()
+		if err != nil {
+			return i, err
+		}
+		p[i] = b
+	}
+	return len(p), nil
+}
+
+func makeCRC8Table(poly uint8) [256]uint8 {
+	var table [256]uint8
+	for i := range table {
+		crc := uint8(i)
+		for j := 0; j < 8; j++ {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ poly
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}
+
+func makeCRC16Table(poly uint16) [256]uint16 {
+	var table [256]uint16
+	for i := range table {
+		crc := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ poly
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}
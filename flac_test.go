@@ -0,0 +1,178 @@
+package goshazam
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+var flacFixtures = []string{"mono16.flac", "stereo24.flac"}
+
+func readFLACFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// decodeFLACFrames decodes every frame of data and returns the stream info, the MD5 of
+// the samples as the encoder computes it, and the samples interleaved and normalized.
+func decodeFLACFrames(t *testing.T, data []byte) (FLACStreamInfo, [16]byte, []float64) {
+	t.Helper()
+	d, err := newFLACReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	width := (int(d.info.BitsPerSample) + 7) / 8
+	scale := 1 / float64(int64(1)<<(d.info.BitsPerSample-1))
+	hash := md5.New()
+	var samples []float64
+	sample := make([]byte, 8)
+	for {
+		block, _, err := d.readFrame()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range block[0] {
+			for c := range block {
+				binary.LittleEndian.PutUint64(sample, uint64(block[c][i]))
+				hash.Write(sample[:width])
+				samples = append(samples, float64(block[c][i])*scale)
+			}
+		}
+	}
+	var sum [16]byte
+	hash.Sum(sum[:0])
+	return d.info, sum, samples
+}
+
+// withFLACSeekTable returns data with a SEEKTABLE block after STREAMINFO that has a
+// point for every frame.
+func withFLACSeekTable(t *testing.T, data []byte) []byte {
+	t.Helper()
+	d, err := newFLACReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var points []byte
+	for {
+		offset := d.bytes.count - d.firstFrame
+		block, first, err := d.readFrame()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		point := make([]byte, 18)
+		binary.BigEndian.PutUint64(point[0:], first)
+		binary.BigEndian.PutUint64(point[8:], uint64(offset))
+		binary.BigEndian.PutUint16(point[16:], uint16(len(block[0])))
+		points = append(points, point...)
+	}
+
+	// STREAMINFO comes first: the marker, a block header and 34 bytes.
+	const infoEnd = 4 + 4 + 34
+	out := slices.Clone(data[:infoEnd])
+	out[4] &^= 0x80
+	header := []byte{flacBlockSeekTable, byte(len(points) >> 16), byte(len(points) >> 8), byte(len(points))}
+	if data[4]&0x80 != 0 {
+		header[0] |= 0x80
+	}
+	out = append(out, header...)
+	out = append(out, points...)
+	return append(out, data[infoEnd:]...)
+}
+
+// countingReader counts the bytes read from a bytes.Reader.
+type countingReader struct {
+	*bytes.Reader
+	read int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.read += n
+	return n, err
+}
+
+func TestDecodeFLACMatchesStreamInfoMD5(t *testing.T) {
+	for _, name := range flacFixtures {
+		info, sum, samples := decodeFLACFrames(t, readFLACFixture(t, name))
+		if sum != info.MD5 {
+			t.Errorf("%s: decoded samples have MD5 %x, the encoder recorded %x", name, sum, info.MD5)
+		}
+		if frames := uint64(len(samples) / int(info.Channels)); frames != info.TotalSamples {
+			t.Errorf("%s: decoded %d samples per channel, want %d", name, frames, info.TotalSamples)
+		}
+	}
+}
+
+func TestDecodeFLACWindow(t *testing.T) {
+	for _, name := range flacFixtures {
+		data := readFLACFixture(t, name)
+		info, _, samples := decodeFLACFrames(t, data)
+		rate, channels := int(info.SampleRate), int(info.Channels)
+		length := framesDuration(int64(info.TotalSamples), rate)
+		seekable := withFLACSeekTable(t, data)
+
+		for _, opts := range []DecodeOptions{
+			{},
+			{Duration: length / 2},
+			{Offset: length / 3},
+			{Offset: length / 3, Duration: length / 4},
+			{Offset: length - time.Millisecond},
+			{Offset: length + time.Second},
+		} {
+			start, count := opts.frames(rate)
+			end := int64(info.TotalSamples)
+			if count > 0 {
+				end = min(end, start+count)
+			}
+			start = min(start, end)
+			want, err := ConvertPCM(samples[start*int64(channels):end*int64(channels)], rate, channels)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for input, r := range map[string]io.Reader{
+				"read through":  readOnly(bytes.NewReader(data)),
+				"no seek table": bytes.NewReader(data),
+				"seek table":    bytes.NewReader(seekable),
+			} {
+				got, err := DecodeFLAC(r, opts)
+				if err != nil {
+					t.Fatalf("%s %s %+v: %v", name, input, opts, err)
+				}
+				if !slices.Equal(got, want) {
+					t.Errorf("%s %s %+v: got %d samples, want the %d converted from the reference window", name, input, opts, len(got), len(want))
+				}
+			}
+		}
+	}
+}
+
+func TestDecodeFLACSeeksToOffset(t *testing.T) {
+	seekable := withFLACSeekTable(t, readFLACFixture(t, "mono16.flac"))
+	opts := DecodeOptions{Offset: 700 * time.Millisecond, Duration: 100 * time.Millisecond}
+	r := &countingReader{Reader: bytes.NewReader(seekable)}
+	if _, err := DecodeFLAC(r, opts); err != nil {
+		t.Fatal(err)
+	}
+	// The seek table lets the decoder jump over the frames before the offset.
+	if r.read > len(seekable)/2 {
+		t.Errorf("read %d of %d bytes to decode from %v", r.read, len(seekable), opts.Offset)
+	}
+}
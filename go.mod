@@ -4,6 +4,7 @@ go 1.23.0
 
 require (
 	github.com/google/uuid v1.1.1
+	github.com/hajimehoshi/go-mp3 v0.3.4
 	github.com/u2takey/ffmpeg-go v0.5.0
 	gonum.org/v1/gonum v0.15.1
 )
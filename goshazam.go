@@ -2,7 +2,10 @@ package goshazam
 
 import (
 	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
 	"math/rand"
 	"net/http"
 	"sync"
@@ -16,10 +19,45 @@ type ShazamClient struct {
 	userAgents [12]string
 	randMu     sync.Mutex
 	rand       *rand.Rand
+	ffmpegPath string
+	decoders   *DecoderSet
+	preprocess []Stage
 }
 
-func NewShazamClient() *ShazamClient {
-	return &ShazamClient{
+// ClientOption configures a ShazamClient at construction time.
+type ClientOption func(*ShazamClient)
+
+// WithFFmpegPath sets the ffmpeg binary used by the default decoder set. It defaults to "ffmpeg" on PATH.
+func WithFFmpegPath(path string) ClientOption {
+	return func(c *ShazamClient) {
+		c.ffmpegPath = path
+	}
+}
+
+// WithDecoders replaces the default decoder set, e.g. to add native decoders or test fakes.
+func WithDecoders(decoders *DecoderSet) ClientOption {
+	return func(c *ShazamClient) {
+		c.decoders = decoders
+	}
+}
+
+// WithHTTPClient sets the HTTP client used to query Shazam and to download audio in RecognizeURL.
+func WithHTTPClient(client *http.Client) ClientOption {
+	return func(c *ShazamClient) {
+		c.client = client
+	}
+}
+
+// WithPreprocessing runs the fingerprinted audio through the given stages, e.g.
+// RemoveDC, HighPass, NormalizeRMS and Limiter for quiet or humming phone recordings.
+func WithPreprocessing(stages ...Stage) ClientOption {
+	return func(c *ShazamClient) {
+		c.preprocess = stages
+	}
+}
+
+func NewShazamClient(opts ...ClientOption) *ShazamClient {
+	c := &ShazamClient{
 		client: &http.Client{
 			Timeout: 30 * time.Second,
 		},
@@ -29,7 +67,15 @@ func NewShazamClient() *ShazamClient {
 		},
 		userAgents: userAgents,
 		rand:       rand.New(rand.NewSource(time.Now().UnixNano())),
+		ffmpegPath: defaultFFmpegPath,
+	}
+	for _, opt := range opts {
+		opt(c)
 	}
+	if c.decoders == nil {
+		c.decoders = newDefaultDecoderSet(c.ffmpegPath)
+	}
+	return c
 }
 
 func (c *ShazamClient) getRandomUserAgent() string {
@@ -46,22 +92,422 @@ func (c *ShazamClient) Do(req *http.Request) (*http.Response, error) {
 	return c.client.Do(req)
 }
 
-// Recognize processes an audio file and returns the recognition result.
-func (c *ShazamClient) Recognize(ctx context.Context, filePath string) (*RecognizeResult, error) {
-	rawPCM, err := GenerateRawPCMInMemory(filePath)
+// RecognizeOption customizes a single recognition request.
+type RecognizeOption func(*recognizeOptions)
+
+type recognizeOptions struct {
+	decode           DecodeOptions
+	durationSet      bool
+	silenceThreshold float64
+	maxSilenceSkip   time.Duration
+	maxDownloadSize  int64
+	interval         time.Duration
+	window           time.Duration
+}
+
+// WithOffset starts recognition at the given position in the input instead of its beginning.
+func WithOffset(offset time.Duration) RecognizeOption {
+	return func(o *recognizeOptions) {
+		o.decode.Offset = offset
+	}
+}
+
+// WithDuration limits how much audio is decoded after the offset. It defaults to the
+// length of the fingerprinted window, which is extended by up to the maximum silence
+// skip only when the window does not start with sound.
+func WithDuration(duration time.Duration) RecognizeOption {
+	return func(o *recognizeOptions) {
+		o.decode.Duration = duration
+		o.durationSet = true
+	}
+}
+
+// WithSilenceThreshold sets the level, in dBFS, below which audio counts as silence.
+// It defaults to DefaultSilenceThreshold; math.Inf(-1) disables silence detection.
+func WithSilenceThreshold(dBFS float64) RecognizeOption {
+	return func(o *recognizeOptions) {
+		o.silenceThreshold = dBFS
+	}
+}
+
+// WithMaxSilenceSkip limits how much leading silence is skipped before the fingerprinted
+// window. It defaults to DefaultMaxSilenceSkip; zero fingerprints the audio at the offset as is.
+func WithMaxSilenceSkip(d time.Duration) RecognizeOption {
+	return func(o *recognizeOptions) {
+		o.maxSilenceSkip = d
+	}
+}
+
+// WithStream selects the audio stream to recognize by its AudioStream.AudioIndex,
+// e.g. a dubbed track of a video. The decoder's default stream is used otherwise.
+func WithStream(index int) RecognizeOption {
+	return func(o *recognizeOptions) {
+		o.decode = o.decode.WithStream(index)
+	}
+}
+
+// WithMaxDownloadSize limits how many bytes RecognizeURL downloads. It defaults to DefaultMaxDownloadSize.
+func WithMaxDownloadSize(n int64) RecognizeOption {
+	return func(o *recognizeOptions) {
+		o.maxDownloadSize = n
+	}
+}
+
+// WithInterval sets how often RecognizeRadio and RecognizeHLS fingerprint the stream. It defaults to
+// DefaultRadioInterval; intervals shorter than the fingerprinted window make windows overlap.
+func WithInterval(d time.Duration) RecognizeOption {
+	return func(o *recognizeOptions) {
+		o.interval = d
+	}
+}
+
+// WithWindow sets how much audio is fingerprinted, between MinSignatureWindow and
+// MaxSignatureWindow. It defaults to DefaultSignatureWindow; shorter windows answer
+// sooner, longer ones hold more peaks for noisy captures.
+func WithWindow(d time.Duration) RecognizeOption {
+	return func(o *recognizeOptions) {
+		o.window = d
+	}
+}
+
+func newRecognizeOptions(opts []RecognizeOption) (recognizeOptions, error) {
+	o := recognizeOptions{
+		silenceThreshold: DefaultSilenceThreshold,
+		maxSilenceSkip:   DefaultMaxSilenceSkip,
+		maxDownloadSize:  DefaultMaxDownloadSize,
+		interval:         DefaultRadioInterval,
+		window:           DefaultSignatureWindow,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if err := CheckSignatureWindow(o.window); err != nil {
+		return o, err
+	}
+	if !o.durationSet {
+		o.decode.Duration = o.window
+	}
+	return o, nil
+}
+
+// silenceExtension returns the part of the input after the decoded window that skipping
+// leading silence may reach, or false when the decode is not extended because WithDuration
+// fixed it or skipping is off.
+func (o recognizeOptions) silenceExtension() (DecodeOptions, bool) {
+	if o.durationSet || o.maxSilenceSkip <= 0 {
+		return DecodeOptions{}, false
+	}
+	rest := o.decode
+	rest.Offset += o.decode.Duration
+	rest.Duration = o.maxSilenceSkip
+	return rest, true
+}
+
+// extended returns o with the decode extended up front as far as skipping silence may
+// need, for input that cannot be decoded a second time.
+func (o recognizeOptions) extended() recognizeOptions {
+	if rest, ok := o.silenceExtension(); ok {
+		o.decode.Duration += rest.Duration
+	}
+	return o
+}
+
+// Recognize processes an audio file and returns the recognition result. The selected
+// part of the file is fingerprinted while it is decoded, so memory use does not grow with
+// the window or the silence skip, let alone with the file's length. Only the stages of
+// WithPreprocessing, which look at all of the audio, need it collected first.
+func (c *ShazamClient) Recognize(ctx context.Context, filePath string, opts ...RecognizeOption) (*RecognizeResult, error) {
+	o, err := newRecognizeOptions(opts)
 	if err != nil {
-		return nil, fmt.Errorf("error generating raw PCM: %w", err)
+		return nil, err
 	}
+	return c.recognizeDecoded(ctx, o, func(opts DecodeOptions) (io.ReadCloser, error) {
+		pcm, err := c.decoders.decodeFileStream(ctx, filePath, opts)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding audio: %w", err)
+		}
+		return decodeErrorReader{pcm}, nil
+	})
+}
 
-	samples, err := ReadSamplesFromBuffer(rawPCM)
+// RecognizeReader processes audio read from r and returns the recognition result.
+// Like Recognize, it fingerprints the audio while it is decoded.
+func (c *ShazamClient) RecognizeReader(ctx context.Context, r io.Reader, opts ...RecognizeOption) (*RecognizeResult, error) {
+	o, err := newRecognizeOptions(opts)
 	if err != nil {
-		return nil, fmt.Errorf("error reading samples from buffer: %w", err)
+		return nil, err
 	}
+	// r can be decoded only once.
+	o = o.extended()
+	pcm, err := c.decoders.decodeReaderStream(ctx, r, "", o.decode)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding audio: %w", err)
+	}
+	defer pcm.Close()
+	return c.recognizeStream(ctx, decodeErrorReader{pcm}, o)
+}
 
-	sg := NewSignatureGenerator()
+// RecognizeAudio recognizes audio that is already decoded, converting it to 16 kHz
+// mono when needed. WithOffset and WithDuration select a part of b, measured from its
+// start. Buffers whose format does not describe their samples fail with ErrInvalidAudioBuffer.
+func (c *ShazamClient) RecognizeAudio(ctx context.Context, b AudioBuffer, opts ...RecognizeOption) (*RecognizeResult, error) {
+	o, err := newRecognizeOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := b.Validate(); err != nil {
+		return nil, err
+	}
+	// All of b is in memory already.
+	o = o.extended()
+	var end time.Duration
+	if o.decode.Duration > 0 {
+		end = o.decode.Offset + o.decode.Duration
+	}
+	b, err = b.Slice(o.decode.Offset, end).ForSignature()
+	if err != nil {
+		return nil, fmt.Errorf("error converting audio: %w", err)
+	}
+	return c.recognizeSamples(ctx, b.Samples, o)
+}
+
+// AudioStreams lists the audio streams of the file at filePath.
+func (c *ShazamClient) AudioStreams(ctx context.Context, filePath string) ([]AudioStream, error) {
+	streams, err := c.decoders.AudioStreams(ctx, filePath)
+	if err != nil {
+		return nil, fmt.Errorf("error listing audio streams: %w", err)
+	}
+	return streams, nil
+}
+
+// Probe describes the file at filePath with the client's decoders, e.g. to plan offsets
+// for long files or to compare embedded tags with recognition results.
+func (c *ShazamClient) Probe(ctx context.Context, filePath string) (*MediaInfo, error) {
+	info, err := c.decoders.Probe(ctx, filePath)
+	if err != nil {
+		return nil, fmt.Errorf("error probing media: %w", err)
+	}
+	return info, nil
+}
+
+// StreamResult is the recognition outcome for one audio stream.
+type StreamResult struct {
+	Stream AudioStream
+	Result *RecognizeResult
+	Err    error
+}
+
+// RecognizeAllStreams recognizes every audio stream of the file at filePath in turn.
+// A failure of one stream is reported in its StreamResult and does not stop the others;
+// only listing errors and context cancellation are returned as err.
+func (c *ShazamClient) RecognizeAllStreams(ctx context.Context, filePath string, opts ...RecognizeOption) ([]StreamResult, error) {
+	streams, err := c.AudioStreams(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]StreamResult, 0, len(streams))
+	for _, stream := range streams {
+		if err := ctx.Err(); err != nil {
+			return results, err
+		}
+		result, err := c.Recognize(ctx, filePath, append(opts[:len(opts):len(opts)], WithStream(stream.AudioIndex))...)
+		results = append(results, StreamResult{Stream: stream, Result: result, Err: err})
+	}
+	return results, nil
+}
+
+// recognizeDecoded recognizes the PCM that decode yields for the fingerprinted window and,
+// only when the window does not start with sound, for the input after it, up to the maximum
+// silence skip, so audible input is decoded no further than the window. That test runs on
+// the decoded samples as they are, which is cheap and leaves the preprocessing to run once
+// over everything decoded.
+func (c *ShazamClient) recognizeDecoded(ctx context.Context, o recognizeOptions, decode func(DecodeOptions) (io.ReadCloser, error)) (*RecognizeResult, error) {
+	pcm := &extendedPCM{decode: decode, window: o.decode, thresholdDB: o.silenceThreshold}
+	pcm.rest, pcm.extend = o.silenceExtension()
+	defer pcm.Close()
+	return c.recognizeStream(ctx, pcm, o)
+}
+
+// extendedPCM reads the PCM decoded for the fingerprinted window and then, when the window
+// is complete but does not start with sound, the PCM decoded for the input after it. Each
+// part is decoded when it is first read.
+type extendedPCM struct {
+	decode      func(DecodeOptions) (io.ReadCloser, error)
+	window      DecodeOptions
+	rest        DecodeOptions
+	extend      bool
+	thresholdDB float64
+	pcm         io.ReadCloser
+	extended    bool   // whether pcm is the part after the window
+	head        []byte // the first silence frame of the window
+	read        int64  // bytes read from the window
+	err         error
+}
+
+func (e *extendedPCM) Read(p []byte) (int, error) {
+	for e.err == nil {
+		if e.pcm == nil {
+			opts := e.window
+			if e.extended {
+				opts = e.rest
+			}
+			e.pcm, e.err = e.decode(opts)
+			continue
+		}
+		n, err := e.pcm.Read(p)
+		if !e.extended {
+			e.read += int64(n)
+			e.head = append(e.head, p[:min(n, 2*silenceFrameSize-len(e.head))]...)
+			if err == io.EOF && e.extendable() {
+				e.extended = true
+				err = e.pcm.Close()
+				e.pcm = nil
+			}
+		}
+		e.err = err
+		if n > 0 {
+			return n, nil
+		}
+	}
+	return 0, e.err
+}
+
+// extendable reports whether the window just read is followed by the part after it.
+func (e *extendedPCM) extendable() bool {
+	// A window cut short by the end of the input has nothing after it.
+	if !e.extend || e.read/2 < int64(windowSamples(e.window.Duration)-silenceFrameSize) {
+		return false
+	}
+	head := make([]int16, len(e.head)/2)
+	for i := range head {
+		head[i] = int16(binary.LittleEndian.Uint16(e.head[i*2:]))
+	}
+	return FirstSound(head, e.thresholdDB) != 0
+}
+
+func (e *extendedPCM) Close() error {
+	if e.pcm == nil {
+		return nil
+	}
+	return e.pcm.Close()
+}
+
+// decodeErrorReader reports the failures of a decoded stream like those of starting to decode it.
+type decodeErrorReader struct {
+	io.ReadCloser
+}
+
+func (r decodeErrorReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		err = fmt.Errorf("error decoding audio: %w", err)
+	}
+	return n, err
+}
+
+// recognizeStream recognizes the first window of sound in the 16 kHz mono PCM read from pcm.
+// The stages of WithPreprocessing look at all of the audio, e.g. to normalize its level, so
+// with them the PCM is collected and passed to recognizeSamples; otherwise it is fingerprinted
+// while it is read.
+func (c *ShazamClient) recognizeStream(ctx context.Context, pcm io.Reader, o recognizeOptions) (*RecognizeResult, error) {
+	if len(c.preprocess) > 0 {
+		samples, err := NewSampleReader(pcm, ffmpegReadChunk).ReadAll()
+		if err != nil {
+			return nil, err
+		}
+		return c.recognizeSamples(ctx, samples, o)
+	}
+	signature, err := signStream(pcm, o)
+	if err != nil {
+		return nil, err
+	}
+	return c.recognizeSignature(ctx, &signature)
+}
+
+// signStream fingerprints the first window of sound in the 16 kHz mono PCM read from pcm.
+// It skips silence like skipSilence does, but reads a frame at a time and feeds the window
+// to the generator as it arrives, so that it holds a single frame in memory.
+func signStream(pcm io.Reader, o recognizeOptions) (DecodedSignature, error) {
+	sg, err := NewSignatureGeneratorWindow(o.window)
+	if err != nil {
+		return DecodedSignature{}, err
+	}
+	reader := NewSampleReader(pcm, silenceFrameSize)
+	maxSkip := int64(max(o.maxSilenceSkip, 0).Seconds() * sampleRate)
+
+	// Drop silent frames until one has sound or skipping reaches the maximum.
+	var frame []int16
+	for skipped := int64(0); ; {
+		chunk, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			if skipped == 0 {
+				return DecodedSignature{}, ErrNoAudio
+			}
+			return DecodedSignature{}, ErrTooQuiet
+		}
+		if err != nil {
+			return DecodedSignature{}, err
+		}
+		if FirstSound(chunk, o.silenceThreshold) == 0 {
+			frame = chunk
+			break
+		}
+		if end := skipped + int64(len(chunk)); end > maxSkip {
+			frame = chunk[maxSkip-skipped:]
+			break
+		}
+		skipped += int64(len(chunk))
+	}
+
+	// Skipping stopped at maxSkip, so the window may still be silent.
+	sound := newSoundDetector(o.silenceThreshold)
+	for remaining := windowSamples(o.window); ; {
+		frame = frame[:min(len(frame), remaining)]
+		sg.Feed(frame)
+		sound.write(frame)
+		if remaining -= len(frame); remaining == 0 {
+			break
+		}
+		chunk, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return DecodedSignature{}, err
+		}
+		frame = chunk
+	}
+	if !sound.sound() {
+		return DecodedSignature{}, ErrTooQuiet
+	}
+	return sg.Flush(), nil
+}
+
+// recognizeSamples runs samples through the client's preprocessing and fingerprints
+// the first window of sound in the result. Silence is detected after preprocessing, so
+// quiet recordings that the chain normalizes are not rejected; input that is silent
+// even then fails with ErrTooQuiet before anything is sent.
+func (c *ShazamClient) recognizeSamples(ctx context.Context, samples []int16, o recognizeOptions) (*RecognizeResult, error) {
+	if len(samples) == 0 {
+		return nil, ErrNoAudio
+	}
+	samples = Preprocess(samples, c.preprocess...)
+	samples, err := skipSilence(samples, o.silenceThreshold, max(o.maxSilenceSkip, 0), o.window)
+	if err != nil {
+		return nil, err
+	}
+
+	sg, err := NewSignatureGeneratorWindow(o.window)
+	if err != nil {
+		return nil, err
+	}
 	signature := sg.MakeSignatureFromBuffer(samples)
+	return c.recognizeSignature(ctx, &signature)
+}
 
-	data, err := GetSignatureJSON(&signature)
+// recognizeSignature sends signature to Shazam.
+func (c *ShazamClient) recognizeSignature(ctx context.Context, signature *DecodedSignature) (*RecognizeResult, error) {
+	data, err := GetSignatureJSON(signature)
 	if err != nil {
 		return nil, fmt.Errorf("error getting signature JSON: %w", err)
 	}
@@ -73,3 +519,47 @@ func (c *ShazamClient) Recognize(ctx context.Context, filePath string) (*Recogni
 
 	return &RecognizeResult{rawData: result}, nil
 }
+
+// recognizeWindows reads 16 kHz mono PCM from pcm until it ends and recognizes its
+// latest window every o.interval, passing where the window starts in pcm to handle.
+// It stops early when ctx is done or handle fails, and returns that error.
+func (c *ShazamClient) recognizeWindows(ctx context.Context, pcm io.Reader, o recognizeOptions, handle func(start time.Duration, result *RecognizeResult, err error) error) error {
+	windowLen := windowSamples(o.window)
+	interval := int64(o.interval.Seconds() * sampleRate)
+	var (
+		window   []int16
+		received int64
+		next     = int64(windowLen)
+	)
+	reader := NewSampleReader(pcm, ffmpegReadChunk)
+	for {
+		chunk, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return ctx.Err()
+		}
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			return fmt.Errorf("error decoding stream: %w", err)
+		}
+		window = append(window, chunk...)
+		if len(window) > windowLen {
+			window = window[:copy(window, window[len(window)-windowLen:])]
+		}
+		received += int64(len(chunk))
+		if received < next {
+			continue
+		}
+		next = received + max(interval, 1)
+
+		start := time.Duration(received-int64(len(window))) * time.Second / sampleRate
+		result, err := c.recognizeSamples(ctx, window, o)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if err := handle(start, result, err); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package goshazam
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"math"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// toneAfterSilence returns 16 kHz mono samples: silence, then a tone lasting sound.
+func toneAfterSilence(silence, sound int) []int16 {
+	samples := make([]int16, silence+sound)
+	for i := silence; i < len(samples); i++ {
+		samples[i] = int16(8000 * math.Sin(2*math.Pi*440*float64(i)/sampleRate))
+	}
+	return samples
+}
+
+// encodeWAV returns a 16-bit stereo WAV file at rate holding silence and then a tone lasting sound.
+func encodeWAV(rate int, silence, sound time.Duration) []byte {
+	silent := int(silence.Seconds() * float64(rate))
+	frames := silent + int(sound.Seconds()*float64(rate))
+	var b bytes.Buffer
+	b.WriteString("RIFF")
+	binary.Write(&b, binary.LittleEndian, uint32(36+frames*4))
+	b.WriteString("WAVEfmt ")
+	binary.Write(&b, binary.LittleEndian, struct {
+		Size                    uint32
+		Format, Channels        uint16
+		Rate, ByteRate          uint32
+		BlockAlign, SampleWidth uint16
+	}{Size: 16, Format: wavFormatPCM, Channels: 2, Rate: uint32(rate), ByteRate: uint32(rate * 4), BlockAlign: 4, SampleWidth: 16})
+	b.WriteString("data")
+	binary.Write(&b, binary.LittleEndian, uint32(frames*4))
+	samples := make([]int16, frames*2)
+	for i := silent; i < frames; i++ {
+		v := int16(8000 * math.Sin(2*math.Pi*440*float64(i)/float64(rate)))
+		samples[i*2], samples[i*2+1] = v, v
+	}
+	binary.Write(&b, binary.LittleEndian, samples)
+	return b.Bytes()
+}
+
+// TestSignStreamMatchesBuffer checks that fingerprinting PCM while it is read skips
+// silence and cuts the window exactly like skipSilence over all of it.
+func TestSignStreamMatchesBuffer(t *testing.T) {
+	for _, silence := range []int{0, 5000, 3*sampleRate + 77} {
+		for _, sound := range []int{0, 300, 4 * sampleRate} {
+			samples := toneAfterSilence(silence, sound)
+			for _, maxSkip := range []time.Duration{0, 2*time.Second + 3*time.Millisecond, DefaultMaxSilenceSkip} {
+				o := recognizeOptions{silenceThreshold: DefaultSilenceThreshold, maxSilenceSkip: maxSkip, window: 2 * time.Second}
+				want, wantErr := DecodedSignature{}, ErrNoAudio
+				if len(samples) > 0 {
+					var window []int16
+					if window, wantErr = skipSilence(samples, o.silenceThreshold, maxSkip, o.window); wantErr == nil {
+						sg, _ := NewSignatureGeneratorWindow(o.window)
+						want = sg.MakeSignatureFromBuffer(window)
+					}
+				}
+
+				var pcm bytes.Buffer
+				binary.Write(&pcm, binary.LittleEndian, samples)
+				got, err := signStream(&pcm, o)
+				if !errors.Is(err, wantErr) {
+					t.Errorf("%d silent and %d sound samples, skip %v: got error %v, want %v", silence, sound, maxSkip, err, wantErr)
+				} else if !reflect.DeepEqual(got, want) {
+					t.Errorf("%d silent and %d sound samples, skip %v: signatures differ", silence, sound, maxSkip)
+				}
+			}
+		}
+	}
+}
+
+// TestSignStreamMemory checks that decoding and fingerprinting a WAV file allocates
+// about the same however long the skipped silence and the window are.
+func TestSignStreamMemory(t *testing.T) {
+	allocated := func(silence, window time.Duration) uint64 {
+		wav := encodeWAV(44100, silence, window+time.Second)
+		o, err := newRecognizeOptions([]RecognizeOption{WithWindow(window)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		o = o.extended()
+
+		var before, after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		pcm, err := DefaultDecoderSet().decodeReaderStream(context.Background(), bytes.NewReader(wav), "", o.decode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature, err := signStream(pcm, o)
+		runtime.ReadMemStats(&after)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := time.Duration(signature.NumberSamples) * time.Second / sampleRate; got != window {
+			t.Fatalf("%v of silence: signature covers %v, want %v", silence, got, window)
+		}
+		return after.TotalAlloc - before.TotalAlloc
+	}
+
+	short := allocated(time.Second, MinSignatureWindow)
+	long := allocated(25*time.Second, MaxSignatureWindow)
+	// Holding the long input would take 37 s of stereo 44.1 kHz audio, tens of megabytes.
+	if long > short+1<<20 {
+		t.Errorf("25 s of silence and a %v window allocate %d bytes, %d more than 1 s and %v", MaxSignatureWindow, long, long-short, MinSignatureWindow)
+	}
+}
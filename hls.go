@@ -0,0 +1,812 @@
+package goshazam
+
+import (
+	"bufio"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// hlsLiveEdgeSegments is how many segments from the end of a live playlist ingestion
+// starts, the closest to the live edge that the HLS specification allows.
+const hlsLiveEdgeSegments = 3
+
+const hlsDateTimeNoColon = "2006-01-02T15:04:05.999999999Z0700"
+
+// hlsReloadTargetDurations is for how many target durations a failing playlist reload
+// is retried before ingestion gives up; the first retry waits hlsReloadBackoff or a
+// quarter of the target duration, and each next one twice as long.
+const (
+	hlsReloadTargetDurations = 3
+	hlsReloadBackoff         = 250 * time.Millisecond
+)
+
+// ErrHLSUnsupported is returned for HLS streams that cannot be ingested, e.g. ones
+// encrypted with SAMPLE-AES.
+var ErrHLSUnsupported = errors.New("unsupported HLS stream")
+
+// HLSMatch is the recognition outcome for one window of an HLS stream.
+type HLSMatch struct {
+	// Offset is where the window starts: the presentation time for VOD playlists,
+	// and the time since ingestion joined the stream for live ones.
+	Offset time.Duration
+	// Sequence is the media sequence number of the segment the window starts in.
+	Sequence int64
+	// ProgramDateTime is the wall-clock time of the window's start taken from the
+	// playlist's EXT-X-PROGRAM-DATE-TIME tags, or zero when it has none.
+	ProgramDateTime time.Time
+	Result          *RecognizeResult
+	Err             error
+}
+
+// hlsByteRange selects part of a resource; a negative length selects all of it.
+type hlsByteRange struct {
+	length int64
+	offset int64
+}
+
+type hlsKey struct {
+	method string
+	uri    string
+	iv     []byte // nil means the segment's media sequence number
+}
+
+// hlsMap is the initialization section of fMP4 segments.
+type hlsMap struct {
+	uri       string
+	byteRange hlsByteRange
+}
+
+type hlsSegment struct {
+	uri      string
+	sequence int64
+	duration time.Duration
+	// start is the segment's position from the start of the playlist.
+	start           time.Duration
+	programDateTime time.Time
+	byteRange       hlsByteRange
+	key             hlsKey
+	init            *hlsMap
+	// discontinuity is set when the segment does not continue the encoding of the one before.
+	discontinuity bool
+}
+
+type hlsVariant struct {
+	uri       string
+	bandwidth int
+	audio     string // group ID of the variant's audio renditions
+}
+
+type hlsRendition struct {
+	uri       string
+	group     string
+	isDefault bool
+}
+
+// hlsPlaylist is either a master playlist listing variants and renditions, or a
+// media playlist listing segments.
+type hlsPlaylist struct {
+	variants       []hlsVariant
+	audio          []hlsRendition
+	targetDuration time.Duration
+	segments       []hlsSegment
+	ended          bool
+}
+
+// parseHLSPlaylist parses an m3u8 playlist, resolving URIs against base.
+func parseHLSPlaylist(r io.Reader, base *url.URL) (*hlsPlaylist, error) {
+	p := &hlsPlaylist{}
+	scanner := bufio.NewScanner(r)
+	if !scanner.Scan() || strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff")) != "#EXTM3U" {
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("not an m3u8 playlist")
+	}
+
+	var (
+		sequence int64
+		start    time.Duration
+		key      hlsKey
+		init     *hlsMap
+		pdt      time.Time
+		variant  *hlsVariant
+		prevURI  string
+		prevEnd  int64
+	)
+	next := hlsSegment{byteRange: hlsByteRange{length: -1}}
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "#") {
+			ref, err := url.Parse(line)
+			if err != nil {
+				return nil, fmt.Errorf("invalid URI %q: %w", line, err)
+			}
+			uri := base.ResolveReference(ref).String()
+			if variant != nil {
+				variant.uri = uri
+				p.variants = append(p.variants, *variant)
+				variant = nil
+				continue
+			}
+			next.uri, next.sequence, next.start, next.key, next.init = uri, sequence, start, key, init
+			if next.byteRange.length >= 0 && next.byteRange.offset < 0 {
+				// A range without offset continues the previous one; some packagers also leave out the first 0.
+				next.byteRange.offset = 0
+				if uri == prevURI {
+					next.byteRange.offset = prevEnd
+				}
+			}
+			prevURI, prevEnd = uri, next.byteRange.offset+next.byteRange.length
+			if !pdt.IsZero() {
+				next.programDateTime = pdt
+				pdt = pdt.Add(next.duration)
+			}
+			p.segments = append(p.segments, next)
+			sequence++
+			start += next.duration
+			next = hlsSegment{byteRange: hlsByteRange{length: -1}}
+			continue
+		}
+
+		tag, value, _ := strings.Cut(line, ":")
+		switch tag {
+		case "#EXT-X-STREAM-INF":
+			attrs := parseHLSAttributes(value)
+			bandwidth, _ := strconv.Atoi(attrs["BANDWIDTH"])
+			variant = &hlsVariant{bandwidth: bandwidth, audio: attrs["AUDIO"]}
+		case "#EXT-X-MEDIA":
+			attrs := parseHLSAttributes(value)
+			if attrs["TYPE"] == "AUDIO" && attrs["URI"] != "" {
+				ref, err := url.Parse(attrs["URI"])
+				if err != nil {
+					return nil, fmt.Errorf("invalid URI %q: %w", attrs["URI"], err)
+				}
+				p.audio = append(p.audio, hlsRendition{
+					uri:       base.ResolveReference(ref).String(),
+					group:     attrs["GROUP-ID"],
+					isDefault: attrs["DEFAULT"] == "YES",
+				})
+			}
+		case "#EXT-X-TARGETDURATION":
+			seconds, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid target duration %q", value)
+			}
+			p.targetDuration = time.Duration(seconds) * time.Second
+		case "#EXT-X-MEDIA-SEQUENCE":
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid media sequence %q", value)
+			}
+			sequence = n
+		case "#EXTINF":
+			durationText, _, _ := strings.Cut(value, ",")
+			seconds, err := strconv.ParseFloat(durationText, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid segment duration %q", durationText)
+			}
+			next.duration = time.Duration(seconds * float64(time.Second))
+		case "#EXT-X-BYTERANGE":
+			byteRange, err := parseHLSByteRange(value)
+			if err != nil {
+				return nil, err
+			}
+			next.byteRange = byteRange
+		case "#EXT-X-KEY":
+			attrs := parseHLSAttributes(value)
+			key = hlsKey{method: attrs["METHOD"]}
+			if uri := attrs["URI"]; uri != "" {
+				ref, err := url.Parse(uri)
+				if err != nil {
+					return nil, fmt.Errorf("invalid key URI %q: %w", uri, err)
+				}
+				key.uri = base.ResolveReference(ref).String()
+			}
+			if iv := attrs["IV"]; iv != "" {
+				b, err := hex.DecodeString(strings.TrimPrefix(strings.TrimPrefix(iv, "0x"), "0X"))
+				if err != nil || len(b) != aes.BlockSize {
+					return nil, fmt.Errorf("invalid key IV %q", iv)
+				}
+				key.iv = b
+			}
+		case "#EXT-X-MAP":
+			attrs := parseHLSAttributes(value)
+			ref, err := url.Parse(attrs["URI"])
+			if err != nil {
+				return nil, fmt.Errorf("invalid map URI %q: %w", attrs["URI"], err)
+			}
+			init = &hlsMap{uri: base.ResolveReference(ref).String(), byteRange: hlsByteRange{length: -1}}
+			if attrs["BYTERANGE"] != "" {
+				if init.byteRange, err = parseHLSByteRange(attrs["BYTERANGE"]); err != nil {
+					return nil, err
+				}
+				init.byteRange.offset = max(init.byteRange.offset, 0)
+			}
+		case "#EXT-X-PROGRAM-DATE-TIME":
+			t, err := time.Parse(time.RFC3339Nano, value)
+			if err != nil {
+				// Some packagers leave the colon out of the zone offset.
+				if t, err = time.Parse(hlsDateTimeNoColon, value); err != nil {
+					return nil, fmt.Errorf("invalid program date-time %q", value)
+				}
+			}
+			pdt = t
+		case "#EXT-X-DISCONTINUITY":
+			next.discontinuity = true
+		case "#EXT-X-ENDLIST":
+			p.ended = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// parseHLSAttributes splits an attribute list such as `BANDWIDTH=128000,CODECS="mp4a.40.2"`.
+func parseHLSAttributes(list string) map[string]string {
+	attrs := make(map[string]string)
+	for list != "" {
+		name, rest, ok := strings.Cut(list, "=")
+		if !ok {
+			break
+		}
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			// Quoted strings may contain commas.
+			value, rest, _ = strings.Cut(rest[1:], `"`)
+			_, rest, _ = strings.Cut(rest, ",")
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		attrs[strings.TrimSpace(name)] = value
+		list = rest
+	}
+	return attrs
+}
+
+// parseHLSByteRange parses "length[@offset]"; a missing offset is returned as -1.
+func parseHLSByteRange(value string) (hlsByteRange, error) {
+	lengthText, offsetText, hasOffset := strings.Cut(value, "@")
+	r := hlsByteRange{offset: -1}
+	var err error
+	if r.length, err = strconv.ParseInt(lengthText, 10, 64); err != nil || r.length < 0 {
+		return r, fmt.Errorf("invalid byte range %q", value)
+	}
+	if hasOffset {
+		if r.offset, err = strconv.ParseInt(offsetText, 10, 64); err != nil || r.offset < 0 {
+			return r, fmt.Errorf("invalid byte range %q", value)
+		}
+	}
+	return r, nil
+}
+
+// fetchHLS requests a playlist, key or segment, or the selected range of it.
+func (c *ShazamClient) fetchHLS(ctx context.Context, uri string, byteRange hlsByteRange) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("User-Agent", c.getRandomUserAgent())
+	if byteRange.length >= 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", byteRange.offset, byteRange.offset+byteRange.length-1))
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && byteRange.length >= 0:
+		return resp.Body, nil
+	case resp.StatusCode == http.StatusOK && byteRange.length >= 0:
+		// The server ignored the range, so cut it out of the whole resource.
+		if _, err := io.CopyN(io.Discard, resp.Body, byteRange.offset); err != nil {
+			resp.Body.Close()
+			return nil, fmt.Errorf("failed to skip to byte range: %w", err)
+		}
+		return struct {
+			io.Reader
+			io.Closer
+		}{io.LimitReader(resp.Body, byteRange.length), resp.Body}, nil
+	case resp.StatusCode == http.StatusOK:
+		return resp.Body, nil
+	default:
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status code %d for %s", resp.StatusCode, uri)
+	}
+}
+
+func (c *ShazamClient) loadHLSPlaylist(ctx context.Context, uri string) (*hlsPlaylist, error) {
+	body, err := c.fetchHLS(ctx, uri, hlsByteRange{length: -1})
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	base, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	return parseHLSPlaylist(body, base)
+}
+
+// openHLS loads the playlist at rawURL and, for a master playlist, the media playlist
+// to ingest: the default audio rendition of the lowest-bandwidth variant when there
+// are separate audio renditions, and the variant itself otherwise. Every variant
+// carries the same audio, so the cheapest one is enough to fingerprint.
+func (c *ShazamClient) openHLS(ctx context.Context, rawURL string) (*hlsPlaylist, string, error) {
+	playlist, err := c.loadHLSPlaylist(ctx, rawURL)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(playlist.variants) == 0 {
+		return playlist, rawURL, nil
+	}
+
+	variant := playlist.variants[0]
+	for _, v := range playlist.variants[1:] {
+		if v.bandwidth < variant.bandwidth {
+			variant = v
+		}
+	}
+	mediaURL := variant.uri
+	for _, rendition := range playlist.audio {
+		if rendition.group == variant.audio && (mediaURL == variant.uri || rendition.isDefault) {
+			mediaURL = rendition.uri
+		}
+	}
+	media, err := c.loadHLSPlaylist(ctx, mediaURL)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(media.variants) > 0 {
+		return nil, "", fmt.Errorf("%w: master playlist %s refers to another master playlist", ErrHLSUnsupported, rawURL)
+	}
+	return media, mediaURL, nil
+}
+
+// hlsFedSegment records where a segment's audio begins in the run it was fed to.
+type hlsFedSegment struct {
+	run     int
+	at      time.Duration
+	segment hlsSegment
+}
+
+// hlsSource fetches the segments of a media playlist and writes them back to back,
+// so the decoder sees one continuous stream. The stream is cut into runs at
+// discontinuities and changes of the initialization section, each decoded on its
+// own. It remembers which segment each part of a run came from.
+type hlsSource struct {
+	client   *ShazamClient
+	url      string
+	live     bool
+	from, to time.Duration // VOD range
+	keys     map[string][]byte
+	init     *hlsMap
+	runs     chan *io.PipeReader
+	w        *io.PipeWriter // the run being fed
+
+	mu        sync.Mutex
+	run       int           // index of the run being fed
+	fed       time.Duration // of the run being fed
+	runStarts []time.Duration
+	timeline  []hlsFedSegment
+}
+
+// feed writes the selected segments of playlist to the runs, reloading live playlists
+// until they end or ctx is done.
+func (s *hlsSource) feed(ctx context.Context, playlist *hlsPlaylist) error {
+	segments := playlist.segments
+	if s.live {
+		segments = segments[max(len(segments)-hlsLiveEdgeSegments, 0):]
+	}
+	last := int64(math.MinInt64)
+	for {
+		fedAny := false
+		for _, segment := range segments {
+			if segment.sequence <= last {
+				continue
+			}
+			if !s.live && (segment.start+segment.duration <= s.from || segment.start >= s.to) {
+				continue
+			}
+			if err := s.feedSegment(ctx, segment); err != nil {
+				return err
+			}
+			last = segment.sequence
+			fedAny = true
+		}
+		if playlist.ended {
+			return nil
+		}
+
+		// Reload after a target duration, or half of one when the playlist had not changed.
+		wait := playlist.targetDuration
+		if !fedAny {
+			wait /= 2
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(max(wait, time.Second)):
+		}
+		var err error
+		if playlist, err = s.reload(ctx, playlist.targetDuration); err != nil {
+			return fmt.Errorf("failed to reload playlist: %w", err)
+		}
+		segments = playlist.segments
+	}
+}
+
+// reload loads the playlist again, retrying with growing pauses for
+// hlsReloadTargetDurations target durations, so a server that briefly fails or
+// serves a partly written playlist does not end ingestion.
+func (s *hlsSource) reload(ctx context.Context, targetDuration time.Duration) (*hlsPlaylist, error) {
+	deadline := time.Now().Add(hlsReloadTargetDurations * max(targetDuration, time.Second))
+	backoff := max(targetDuration/4, hlsReloadBackoff)
+	for {
+		playlist, err := s.client.loadHLSPlaylist(ctx, s.url)
+		if err == nil || ctx.Err() != nil || time.Now().Add(backoff).After(deadline) {
+			return playlist, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (s *hlsSource) feedSegment(ctx context.Context, segment hlsSegment) error {
+	newInit := segment.init != nil && (s.init == nil || *segment.init != *s.init)
+	// The decoder cannot follow a change of encoding parameters or of the initialization section.
+	if s.w == nil || segment.discontinuity || newInit {
+		if err := s.startRun(ctx); err != nil {
+			return err
+		}
+	}
+	if newInit {
+		if err := s.copyResource(ctx, s.w, segment.init.uri, segment.init.byteRange); err != nil {
+			return fmt.Errorf("failed to fetch initialization section: %w", err)
+		}
+		s.init = segment.init
+	}
+
+	s.mu.Lock()
+	s.timeline = append(s.timeline, hlsFedSegment{run: s.run, at: s.fed, segment: segment})
+	s.fed += segment.duration
+	s.mu.Unlock()
+
+	var err error
+	switch segment.key.method {
+	case "", "NONE":
+		err = s.copyResource(ctx, s.w, segment.uri, segment.byteRange)
+	case "AES-128":
+		err = s.copyDecrypted(ctx, s.w, segment)
+	default:
+		return fmt.Errorf("%w: %s encryption", ErrHLSUnsupported, segment.key.method)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to fetch segment %d: %w", segment.sequence, err)
+	}
+	return nil
+}
+
+// startRun ends the run being fed and hands a new one to the decoder.
+func (s *hlsSource) startRun(ctx context.Context) error {
+	pr, pw := io.Pipe()
+	if s.w != nil {
+		s.w.Close()
+	}
+	select {
+	case s.runs <- pr:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	s.mu.Lock()
+	if s.w != nil {
+		s.run++
+	}
+	s.fed = 0
+	s.mu.Unlock()
+	s.w = pw
+	return nil
+}
+
+// finish ends the run being fed with err, or at the end of its input when err is nil,
+// and tells the decoder that no run follows.
+func (s *hlsSource) finish(err error) {
+	if s.w != nil {
+		s.w.CloseWithError(err)
+	}
+	close(s.runs)
+}
+
+func (s *hlsSource) copyResource(ctx context.Context, w io.Writer, uri string, byteRange hlsByteRange) error {
+	body, err := s.client.fetchHLS(ctx, uri, byteRange)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	_, err = io.Copy(w, body)
+	return err
+}
+
+// copyDecrypted fetches a segment encrypted with AES-128-CBC and writes its plaintext.
+func (s *hlsSource) copyDecrypted(ctx context.Context, w io.Writer, segment hlsSegment) error {
+	key, err := s.key(ctx, segment.key.uri)
+	if err != nil {
+		return err
+	}
+	iv := segment.key.iv
+	if iv == nil {
+		iv = make([]byte, aes.BlockSize)
+		binary.BigEndian.PutUint64(iv[8:], uint64(segment.sequence))
+	}
+
+	body, err := s.client.fetchHLS(ctx, segment.uri, segment.byteRange)
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(body)
+	body.Close()
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return fmt.Errorf("encrypted segment of %d bytes is not a whole number of blocks", len(data))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(data, data)
+	padding := int(data[len(data)-1])
+	if padding == 0 || padding > aes.BlockSize {
+		return errors.New("invalid padding in decrypted segment")
+	}
+	_, err = w.Write(data[:len(data)-padding])
+	return err
+}
+
+// key returns the AES-128 key at uri, fetching it once.
+func (s *hlsSource) key(ctx context.Context, uri string) ([]byte, error) {
+	if key, ok := s.keys[uri]; ok {
+		return key, nil
+	}
+	body, err := s.client.fetchHLS(ctx, uri, hlsByteRange{length: -1})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch key: %w", err)
+	}
+	defer body.Close()
+	key, err := io.ReadAll(io.LimitReader(body, aes.BlockSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch key: %w", err)
+	}
+	if len(key) != aes.BlockSize {
+		return nil, fmt.Errorf("key at %s is %d bytes long, not %d", uri, len(key), aes.BlockSize)
+	}
+	s.keys[uri] = key
+	return key, nil
+}
+
+// startDecoding records that the decoder started on the next run at position at of
+// the decoded audio.
+func (s *hlsSource) startDecoding(at time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.runStarts = append(s.runStarts, at)
+}
+
+// position returns where a fed segment begins in the decoded audio, or false when the
+// decoder has not reached its run yet.
+func (s *hlsSource) position(fed hlsFedSegment) (time.Duration, bool) {
+	if fed.run >= len(s.runStarts) {
+		return 0, false
+	}
+	return s.runStarts[fed.run] + fed.at, true
+}
+
+// locate maps a position in the decoded audio to the segment it came from. Positions
+// only grow, so segments before the located one are forgotten.
+func (s *hlsSource) locate(at time.Duration) HLSMatch {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := 0
+	for i+1 < len(s.timeline) {
+		if start, ok := s.position(s.timeline[i+1]); !ok || start > at {
+			break
+		}
+		i++
+	}
+	s.timeline = s.timeline[i:]
+	match := HLSMatch{Offset: at}
+	if len(s.timeline) == 0 {
+		return match
+	}
+	fed := s.timeline[0]
+	start, ok := s.position(fed)
+	if !ok {
+		return match
+	}
+	into := at - start
+	match.Sequence = fed.segment.sequence
+	if !s.live {
+		match.Offset = fed.segment.start + into
+	}
+	if !fed.segment.programDateTime.IsZero() {
+		match.ProgramDateTime = fed.segment.programDateTime.Add(into)
+	}
+	return match
+}
+
+// hlsDecoder decodes each run of an hlsSource with a decoder of its own and reads
+// their output back to back, as 16 kHz mono PCM.
+type hlsDecoder struct {
+	ctx      context.Context
+	decoders *DecoderSet
+	name     string
+	source   *hlsSource
+	run      *io.PipeReader
+	pcm      io.ReadCloser
+	decoded  int64 // bytes of PCM read
+	skip     int64 // bytes of PCM yet to drop from the start
+}
+
+func (d *hlsDecoder) Read(p []byte) (int, error) {
+	for {
+		if d.pcm == nil {
+			if err := d.next(); err != nil {
+				return 0, err
+			}
+		}
+		n, err := d.pcm.Read(p)
+		d.decoded += int64(n)
+		if d.skip > 0 {
+			drop := int(min(int64(n), d.skip))
+			n = copy(p, p[drop:n])
+			d.skip -= int64(drop)
+		}
+		if errors.Is(err, io.EOF) {
+			// The run ended; go on with the next one.
+			d.closeRun()
+			err = nil
+		}
+		if n == 0 && err == nil {
+			continue
+		}
+		return n, err
+	}
+}
+
+// next starts decoding the next run, and returns io.EOF when there is none.
+func (d *hlsDecoder) next() error {
+	var ok bool
+	select {
+	case d.run, ok = <-d.source.runs:
+		if !ok {
+			return io.EOF
+		}
+	case <-d.ctx.Done():
+		return d.ctx.Err()
+	}
+	pcm, err := d.decoders.DecodeLive(d.ctx, d.run, d.name)
+	if err != nil {
+		d.run.CloseWithError(err)
+		return err
+	}
+	d.pcm = pcm
+	d.source.startDecoding(time.Duration(d.decoded/2) * time.Second / sampleRate)
+	return nil
+}
+
+func (d *hlsDecoder) closeRun() {
+	if d.pcm != nil {
+		d.pcm.Close()
+		d.run.Close()
+		d.pcm = nil
+	}
+}
+
+// Close stops decoding the current run, which also stops feeding it.
+func (d *hlsDecoder) Close() error {
+	d.closeRun()
+	return nil
+}
+
+// RecognizeHLS ingests the HLS stream at rawURL, a master or media playlist, and
+// fingerprints a rolling window of its audio every WithInterval, calling handle
+// with each outcome. Segments are fetched back to back and decoded as one
+// continuous stream by the client's decoders, which start over at discontinuities
+// and changes of the initialization section; AAC in MPEG-TS or fMP4 segments is
+// decoded by the ffmpeg fallback. Each match reports the segment and time it
+// starts at, see HLSMatch.
+//
+// Live playlists are joined three segments from the live edge and followed until
+// ctx is done or the playlist ends; a failing reload is retried for a few target
+// durations. VOD playlists are ingested from WithOffset for WithDuration, or to the
+// end when no duration is given; the audio before the offset in its segment is
+// skipped, while the window ends with the segment it ends in. It returns like
+// RecognizeRadio.
+func (c *ShazamClient) RecognizeHLS(ctx context.Context, rawURL string, handle func(HLSMatch) error, opts ...RecognizeOption) error {
+	o, err := newRecognizeOptions(opts)
+	if err != nil {
+		return err
+	}
+	playlist, mediaURL, err := c.openHLS(ctx, rawURL)
+	if err != nil {
+		return fmt.Errorf("error loading playlist: %w", err)
+	}
+	if len(playlist.segments) == 0 {
+		return fmt.Errorf("error loading playlist: %s has no segments", mediaURL)
+	}
+
+	source := &hlsSource{
+		client: c,
+		url:    mediaURL,
+		live:   !playlist.ended,
+		from:   o.decode.Offset,
+		to:     time.Duration(math.MaxInt64),
+		keys:   make(map[string][]byte),
+		runs:   make(chan *io.PipeReader),
+	}
+	if o.durationSet && o.decode.Duration > 0 {
+		source.to = o.decode.Offset + o.decode.Duration
+	}
+	// Segments are fetched whole, so the first one fed may begin before the offset.
+	var skip int64
+	if !source.live {
+		for _, segment := range playlist.segments {
+			if segment.start+segment.duration > source.from {
+				skip = int64(max(source.from-segment.start, 0).Seconds() * sampleRate)
+				break
+			}
+		}
+	}
+
+	feedCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	feedErr := make(chan error, 1)
+	go func() {
+		err := source.feed(feedCtx, playlist)
+		source.finish(err)
+		feedErr <- err
+	}()
+
+	// The segment name helps to pick a decoder when sniffing fails, e.g. for raw AAC.
+	var name string
+	if u, err := url.Parse(playlist.segments[0].uri); err == nil {
+		name = path.Base(u.Path)
+	}
+	pcm := &hlsDecoder{ctx: feedCtx, decoders: c.decoders, name: name, source: source, skip: 2 * skip}
+	skipped := time.Duration(skip) * time.Second / sampleRate
+	err = c.recognizeWindows(ctx, pcm, o, func(start time.Duration, result *RecognizeResult, err error) error {
+		match := source.locate(start + skipped)
+		match.Result, match.Err = result, err
+		return handle(match)
+	})
+	pcm.Close()
+	cancel()
+	// The decoder only runs out of input when feeding stopped, possibly because it failed.
+	if fetchErr := <-feedErr; err == nil && fetchErr != nil {
+		return fmt.Errorf("error fetching segments: %w", fetchErr)
+	}
+	return err
+}
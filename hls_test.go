@@ -0,0 +1,492 @@
+package goshazam
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseHLSPlaylist(t *testing.T) {
+	base, _ := url.Parse("https://example.com/live/index.m3u8")
+	whole := hlsByteRange{length: -1}
+	for _, tt := range []struct {
+		name     string
+		playlist string
+		want     *hlsPlaylist
+	}{
+		{
+			name: "byte ranges without offset",
+			playlist: `#EXTM3U
+#EXT-X-TARGETDURATION:4
+#EXTINF:4,
+#EXT-X-BYTERANGE:1000
+media.ts
+#EXTINF:4,
+#EXT-X-BYTERANGE:2000
+media.ts
+#EXTINF:4,
+#EXT-X-BYTERANGE:500@100
+other.ts
+#EXTINF:4,
+#EXT-X-BYTERANGE:300
+media.ts
+#EXT-X-ENDLIST
+`,
+			want: &hlsPlaylist{
+				targetDuration: 4 * time.Second,
+				ended:          true,
+				segments: []hlsSegment{
+					{uri: "https://example.com/live/media.ts", sequence: 0, duration: 4 * time.Second, byteRange: hlsByteRange{length: 1000, offset: 0}},
+					{uri: "https://example.com/live/media.ts", sequence: 1, duration: 4 * time.Second, start: 4 * time.Second, byteRange: hlsByteRange{length: 2000, offset: 1000}},
+					{uri: "https://example.com/live/other.ts", sequence: 2, duration: 4 * time.Second, start: 8 * time.Second, byteRange: hlsByteRange{length: 500, offset: 100}},
+					// The range before is of another resource, so this one starts at its beginning.
+					{uri: "https://example.com/live/media.ts", sequence: 3, duration: 4 * time.Second, start: 12 * time.Second, byteRange: hlsByteRange{length: 300, offset: 0}},
+				},
+			},
+		},
+		{
+			name: "program date-time without colon in the zone",
+			playlist: `#EXTM3U
+#EXT-X-TARGETDURATION:3
+#EXT-X-MEDIA-SEQUENCE:7
+#EXT-X-PROGRAM-DATE-TIME:2024-03-01T12:00:00.500+0200
+#EXTINF:2.5,
+a.ts
+#EXTINF:2.5,
+b.ts
+`,
+			want: &hlsPlaylist{
+				targetDuration: 3 * time.Second,
+				segments: []hlsSegment{
+					{uri: "https://example.com/live/a.ts", sequence: 7, duration: 2500 * time.Millisecond, byteRange: whole,
+						programDateTime: time.Date(2024, 3, 1, 10, 0, 0, 5e8, time.UTC)},
+					{uri: "https://example.com/live/b.ts", sequence: 8, duration: 2500 * time.Millisecond, start: 2500 * time.Millisecond, byteRange: whole,
+						programDateTime: time.Date(2024, 3, 1, 10, 0, 3, 0, time.UTC)},
+				},
+			},
+		},
+		{
+			name: "initialization sections and discontinuity",
+			playlist: `#EXTM3U
+#EXT-X-TARGETDURATION:2
+#EXT-X-MAP:URI="init.mp4",BYTERANGE="720@0"
+#EXTINF:2,
+one.m4s
+#EXTINF:2,
+two.m4s
+#EXT-X-DISCONTINUITY
+#EXT-X-MAP:URI="/ad/init.mp4"
+#EXTINF:2,
+/ad/one.m4s
+`,
+			want: &hlsPlaylist{
+				targetDuration: 2 * time.Second,
+				segments: []hlsSegment{
+					{uri: "https://example.com/live/one.m4s", sequence: 0, duration: 2 * time.Second, byteRange: whole,
+						init: &hlsMap{uri: "https://example.com/live/init.mp4", byteRange: hlsByteRange{length: 720}}},
+					{uri: "https://example.com/live/two.m4s", sequence: 1, duration: 2 * time.Second, start: 2 * time.Second, byteRange: whole,
+						init: &hlsMap{uri: "https://example.com/live/init.mp4", byteRange: hlsByteRange{length: 720}}},
+					{uri: "https://example.com/ad/one.m4s", sequence: 2, duration: 2 * time.Second, start: 4 * time.Second, byteRange: whole,
+						init: &hlsMap{uri: "https://example.com/ad/init.mp4", byteRange: whole}, discontinuity: true},
+				},
+			},
+		},
+		{
+			name: "keys",
+			playlist: `#EXTM3U
+#EXT-X-TARGETDURATION:2
+#EXT-X-KEY:METHOD=AES-128,URI="key.bin",IV=0x000102030405060708090A0B0C0D0E0F
+#EXTINF:2,
+a.ts
+#EXT-X-KEY:METHOD=AES-128,URI="https://keys.example.com/k"
+#EXTINF:2,
+b.ts
+#EXT-X-KEY:METHOD=NONE
+#EXTINF:2,
+c.ts
+`,
+			want: &hlsPlaylist{
+				targetDuration: 2 * time.Second,
+				segments: []hlsSegment{
+					{uri: "https://example.com/live/a.ts", sequence: 0, duration: 2 * time.Second, byteRange: whole,
+						key: hlsKey{method: "AES-128", uri: "https://example.com/live/key.bin",
+							iv: []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}}},
+					{uri: "https://example.com/live/b.ts", sequence: 1, duration: 2 * time.Second, start: 2 * time.Second, byteRange: whole,
+						key: hlsKey{method: "AES-128", uri: "https://keys.example.com/k"}},
+					{uri: "https://example.com/live/c.ts", sequence: 2, duration: 2 * time.Second, start: 4 * time.Second, byteRange: whole,
+						key: hlsKey{method: "NONE"}},
+				},
+			},
+		},
+		{
+			name: "master playlist",
+			playlist: `#EXTM3U
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aac",NAME="English",DEFAULT=YES,URI="audio/en.m3u8"
+#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID="subs",NAME="English",URI="subs/en.m3u8"
+#EXT-X-STREAM-INF:BANDWIDTH=800000,CODECS="avc1.4d401f,mp4a.40.2",AUDIO="aac"
+high.m3u8
+`,
+			want: &hlsPlaylist{
+				variants: []hlsVariant{{uri: "https://example.com/live/high.m3u8", bandwidth: 800000, audio: "aac"}},
+				audio:    []hlsRendition{{uri: "https://example.com/live/audio/en.m3u8", group: "aac", isDefault: true}},
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseHLSPlaylist(strings.NewReader(tt.playlist), base)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := range got.segments {
+				if pdt := got.segments[i].programDateTime; !pdt.IsZero() {
+					got.segments[i].programDateTime = pdt.UTC()
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOpenHLSSelectsVariant(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		master string
+		want   string
+	}{
+		{
+			name: "lowest bandwidth",
+			master: `#EXTM3U
+#EXT-X-STREAM-INF:BANDWIDTH=800000
+high.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=200000
+low.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=400000
+mid.m3u8
+`,
+			want: "/low.m3u8",
+		},
+		{
+			name: "default audio rendition",
+			master: `#EXTM3U
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="low",NAME="English",URI="audio/en.m3u8"
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="low",NAME="Deutsch",DEFAULT=YES,URI="audio/de.m3u8"
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="high",NAME="English",DEFAULT=YES,URI="audio/hi.m3u8"
+#EXT-X-STREAM-INF:BANDWIDTH=800000,AUDIO="high"
+high.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=200000,AUDIO="low"
+low.m3u8
+`,
+			want: "/audio/de.m3u8",
+		},
+		{
+			name: "first audio rendition",
+			master: `#EXTM3U
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aac",NAME="English",URI="audio/en.m3u8"
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aac",NAME="Deutsch",URI="audio/de.m3u8"
+#EXT-X-STREAM-INF:BANDWIDTH=200000,AUDIO="aac"
+low.m3u8
+`,
+			want: "/audio/en.m3u8",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/master.m3u8" {
+					io.WriteString(w, tt.master)
+					return
+				}
+				io.WriteString(w, "#EXTM3U\n#EXT-X-TARGETDURATION:2\n#EXTINF:2,\nseg.ts\n#EXT-X-ENDLIST\n")
+			}))
+			defer ts.Close()
+			c := NewShazamClient(WithHTTPClient(ts.Client()))
+
+			playlist, mediaURL, err := c.openHLS(context.Background(), ts.URL+"/master.m3u8")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if mediaURL != ts.URL+tt.want {
+				t.Errorf("got media playlist %s, want %s", mediaURL, ts.URL+tt.want)
+			}
+			if len(playlist.segments) != 1 {
+				t.Errorf("got %d segments, want the one of the media playlist", len(playlist.segments))
+			}
+		})
+	}
+}
+
+func TestHLSDecryptsWithSequenceIV(t *testing.T) {
+	key, _ := hex.DecodeString("2b7e151628aed2a6abf7158809cf4f3c")
+	// Without an IV attribute the IV is the media sequence number, big-endian in 16 bytes.
+	iv, _ := hex.DecodeString("00000000000000000000000000000102")
+	const sequence = 0x102
+	plaintext := []byte("AES-128 encrypted HLS segment of some length")
+
+	padding := aes.BlockSize - len(plaintext)%aes.BlockSize
+	ciphertext := append(bytes.Clone(plaintext), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, ciphertext)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/key.bin":
+			w.Write(key)
+		case "/seg.ts":
+			w.Write(ciphertext)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+	c := NewShazamClient(WithHTTPClient(ts.Client()))
+
+	for _, tt := range []struct {
+		name     string
+		sequence int64
+		iv       []byte
+	}{
+		{"sequence number", sequence, nil},
+		{"IV attribute", 1, iv},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			source := &hlsSource{client: c, keys: make(map[string][]byte)}
+			segment := hlsSegment{
+				uri:       ts.URL + "/seg.ts",
+				sequence:  tt.sequence,
+				byteRange: hlsByteRange{length: -1},
+				key:       hlsKey{method: "AES-128", uri: ts.URL + "/key.bin", iv: tt.iv},
+			}
+			var got bytes.Buffer
+			if err := source.copyDecrypted(context.Background(), &got, segment); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got.Bytes(), plaintext) {
+				t.Errorf("got %q, want %q", got.Bytes(), plaintext)
+			}
+		})
+	}
+}
+
+// rawPCMDecoder takes its input to be signed 16-bit little-endian 16 kHz mono PCM, and
+// counts how often it was started on live input.
+type rawPCMDecoder struct {
+	started atomic.Int32
+}
+
+func (d *rawPCMDecoder) Decode(_ context.Context, r io.Reader, _ DecodeOptions) ([]int16, error) {
+	return readDecoded(io.NopCloser(r))
+}
+
+func (d *rawPCMDecoder) DecodeLive(_ context.Context, r io.Reader) (io.ReadCloser, error) {
+	d.started.Add(1)
+	return io.NopCloser(r), nil
+}
+
+// encodePCM returns n samples of signed 16-bit little-endian PCM whose values are sample(i).
+func encodePCM(n int, sample func(i int) int16) []byte {
+	data := make([]byte, 2*n)
+	for i := range n {
+		binary.LittleEndian.PutUint16(data[2*i:], uint16(sample(i)))
+	}
+	return data
+}
+
+// hlsTestClient returns a client for server that decodes raw PCM segments and passes
+// silence to the recognizer, so nothing is sent, while windowStarts receives the first
+// sample of each window.
+func hlsTestClient(server *httptest.Server, decoder *rawPCMDecoder, windowStarts *[]int16) *ShazamClient {
+	decoders := NewDecoderSet(nil)
+	decoders.Register(Format{Name: "raw", Match: func([]byte) bool { return true }, Decoder: decoder})
+	capture := func(samples []float64) []float64 {
+		*windowStarts = append(*windowStarts, int16(math.Round(samples[0]*(1<<15))))
+		return make([]float64, len(samples))
+	}
+	return NewShazamClient(WithHTTPClient(server.Client()), WithDecoders(decoders), WithPreprocessing(capture))
+}
+
+func TestRecognizeHLSVOD(t *testing.T) {
+	const (
+		segments       = 10
+		segmentSamples = 2 * sampleRate
+	)
+	base := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	playlist := fmt.Sprintf("#EXTM3U\n#EXT-X-TARGETDURATION:2\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-PROGRAM-DATE-TIME:%s\n",
+		base.Format(time.RFC3339))
+	for i := range segments {
+		playlist += fmt.Sprintf("#EXTINF:2,\nseg%d.raw\n", i)
+	}
+	playlist += "#EXT-X-ENDLIST\n"
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n int
+		if r.URL.Path == "/vod.m3u8" {
+			io.WriteString(w, playlist)
+		} else if _, err := fmt.Sscanf(r.URL.Path, "/seg%d.raw", &n); err == nil {
+			// Each sample holds its position in the stream in hundredths of a second.
+			w.Write(encodePCM(segmentSamples, func(i int) int16 { return int16((n*segmentSamples + i) / (sampleRate / 100)) }))
+		} else {
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	decoder := &rawPCMDecoder{}
+	var windowStarts []int16
+	c := hlsTestClient(ts, decoder, &windowStarts)
+
+	const offset, duration = 5 * time.Second, 6 * time.Second
+	var matches []HLSMatch
+	err := c.RecognizeHLS(context.Background(), ts.URL+"/vod.m3u8", func(m HLSMatch) error {
+		matches = append(matches, m)
+		return nil
+	}, WithOffset(offset), WithDuration(duration), WithWindow(time.Second), WithInterval(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(matches) < 4 {
+		t.Fatalf("got %d matches, want one a second", len(matches))
+	}
+	if first := matches[0].Offset; first < offset || first >= offset+time.Second {
+		t.Errorf("first window starts at %v, want within a second after the offset %v", first, offset)
+	}
+	for i, m := range matches {
+		if m.Err != ErrTooQuiet {
+			t.Errorf("match %d: got error %v, want ErrTooQuiet", i, m.Err)
+		}
+		// The window's audio must start where the match says it does.
+		if audioAt := time.Duration(windowStarts[i]) * 10 * time.Millisecond; m.Offset < audioAt || m.Offset >= audioAt+10*time.Millisecond {
+			t.Errorf("match %d: at %v, but its audio is from %v", i, m.Offset, audioAt)
+		}
+		if want := int64(m.Offset / (2 * time.Second)); m.Sequence != want {
+			t.Errorf("match %d: at %v in segment %d, want %d", i, m.Offset, m.Sequence, want)
+		}
+		if want := base.Add(m.Offset); !m.ProgramDateTime.Equal(want) {
+			t.Errorf("match %d: program date-time %v, want %v", i, m.ProgramDateTime, want)
+		}
+	}
+	if last := matches[len(matches)-1].Offset; last >= 12*time.Second {
+		t.Errorf("last window starts at %v, after the segment the duration ends in", last)
+	}
+	if n := decoder.started.Load(); n != 1 {
+		t.Errorf("decoder started %d times, want once", n)
+	}
+}
+
+func TestRecognizeHLSLive(t *testing.T) {
+	base := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	// Segment 4 holds more audio than its EXTINF says, and segment 5 starts a new encoding.
+	segmentSamples := func(n int) int {
+		if n == 4 {
+			return 3 * sampleRate / 2
+		}
+		return sampleRate
+	}
+	media := func(first, last int, ended bool) string {
+		p := fmt.Sprintf("#EXTM3U\n#EXT-X-TARGETDURATION:1\n#EXT-X-MEDIA-SEQUENCE:%d\n#EXT-X-PROGRAM-DATE-TIME:%s\n",
+			first, base.Add(time.Duration(first)*time.Second).Format(time.RFC3339))
+		for n := first; n <= last; n++ {
+			if n == 5 {
+				p += "#EXT-X-DISCONTINUITY\n"
+			}
+			p += fmt.Sprintf("#EXTINF:1.0,\nseg%d.raw\n", n)
+		}
+		if ended {
+			p += "#EXT-X-ENDLIST\n"
+		}
+		return p
+	}
+
+	var (
+		mu     sync.Mutex
+		reload int
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n int
+		if r.URL.Path == "/live.m3u8" {
+			mu.Lock()
+			reload++
+			count := reload
+			mu.Unlock()
+			switch count {
+			case 1:
+				io.WriteString(w, media(0, 4, false))
+			case 2:
+				// The first reload fails and is retried.
+				http.Error(w, "busy", http.StatusServiceUnavailable)
+			default:
+				io.WriteString(w, media(2, 6, true))
+			}
+		} else if _, err := fmt.Sscanf(r.URL.Path, "/seg%d.raw", &n); err == nil {
+			// Each sample holds its segment and its position in it in milliseconds, all below two seconds.
+			w.Write(encodePCM(segmentSamples(n), func(i int) int16 { return int16(n*2000 + i/(sampleRate/1000)) }))
+		} else {
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	decoder := &rawPCMDecoder{}
+	var windowStarts []int16
+	c := hlsTestClient(ts, decoder, &windowStarts)
+
+	var matches []HLSMatch
+	err := c.RecognizeHLS(context.Background(), ts.URL+"/live.m3u8", func(m HLSMatch) error {
+		matches = append(matches, m)
+		return nil
+	}, WithWindow(time.Second), WithInterval(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(matches) < 4 {
+		t.Fatalf("got %d matches, want one a second", len(matches))
+	}
+	afterDiscontinuity := false
+	for i, m := range matches {
+		sequence, into := int64(windowStarts[i]/2000), time.Duration(windowStarts[i]%2000)*time.Millisecond
+		if m.Sequence != sequence {
+			t.Errorf("match %d: in segment %d, but its audio is from segment %d", i, m.Sequence, sequence)
+		}
+		// Live ingestion joins three segments from the live edge, at segment 2.
+		want := base.Add(time.Duration(sequence)*time.Second + into)
+		if d := m.ProgramDateTime.Sub(want); d < 0 || d >= time.Millisecond {
+			t.Errorf("match %d: program date-time %v, want %v", i, m.ProgramDateTime, want)
+		}
+		if i > 0 && m.Offset <= matches[i-1].Offset {
+			t.Errorf("match %d: at %v, not after the previous one at %v", i, m.Offset, matches[i-1].Offset)
+		}
+		afterDiscontinuity = afterDiscontinuity || sequence >= 5
+	}
+	if matches[0].Sequence != 2 {
+		t.Errorf("first window in segment %d, want 2, three segments from the live edge", matches[0].Sequence)
+	}
+	if !afterDiscontinuity {
+		t.Error("no window after the discontinuity")
+	}
+	if n := decoder.started.Load(); n != 2 {
+		t.Errorf("decoder started %d times, want twice: at the start and at the discontinuity", n)
+	}
+	if reload < 3 {
+		t.Errorf("playlist loaded %d times, want the failed reload retried", reload)
+	}
+}
@@ -0,0 +1,214 @@
+package goshazam
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf16"
+)
+
+const (
+	id3HeaderSize   = 10
+	id3v1Size       = 128 // the ID3v1 tag at the end of a file
+	id3FlagUnsync   = 0x80
+	id3FlagExtended = 0x40
+	// Second frame flag byte: compression and encryption in ID3v2.3 and ID3v2.4, then
+	// unsynchronisation and the data length indicator, which only ID3v2.4 has.
+	id3v23FrameFlagsOpaque = 0xC0
+	id3v24FrameFlagsOpaque = 0x0C
+	id3v24FrameFlagUnsync  = 0x02
+	id3v24FrameFlagLength  = 0x01
+)
+
+// id3TextFrames maps ID3v2.3/2.4 text frame IDs to the tag names used by Vorbis comments.
+var id3TextFrames = map[string]string{
+	"TIT2": "title",
+	"TPE1": "artist",
+	"TPE2": "albumartist",
+	"TALB": "album",
+	"TCOM": "composer",
+	"TCON": "genre",
+	"TRCK": "tracknumber",
+	"TPOS": "discnumber",
+	"TYER": "date",
+	"TDRC": "date",
+	"TSRC": "isrc",
+	"TPUB": "publisher",
+}
+
+// id3v22TextFrames is id3TextFrames for the three-character IDs of ID3v2.2.
+var id3v22TextFrames = map[string]string{
+	"TT2": "title",
+	"TP1": "artist",
+	"TP2": "albumartist",
+	"TAL": "album",
+	"TCM": "composer",
+	"TCO": "genre",
+	"TRK": "tracknumber",
+	"TPA": "discnumber",
+	"TYE": "date",
+	"TRC": "isrc",
+	"TPB": "publisher",
+}
+
+// readID3v2 reads a leading ID3v2 tag from r and returns its text frames as
+// tags along with the number of bytes consumed. It consumes nothing and
+// returns no tags when r does not start with a tag.
+func readID3v2(r io.ReadSeeker) (map[string]string, int64, error) {
+	header := make([]byte, id3HeaderSize)
+	n, err := io.ReadFull(r, header)
+	if _, seekErr := r.Seek(-int64(n), io.SeekCurrent); seekErr != nil {
+		return nil, 0, seekErr
+	}
+	size := skipID3v2Header(header[:n])
+	if err != nil || size == 0 {
+		return nil, 0, nil
+	}
+
+	tag := make([]byte, size)
+	if _, err := io.ReadFull(r, tag); err != nil {
+		return nil, 0, fmt.Errorf("failed to read ID3v2 tag: %w", err)
+	}
+	return parseID3v2(tag), int64(size), nil
+}
+
+// parseID3v2 extracts the text frames of a complete ID3v2 tag, header included.
+// Frames it cannot interpret are skipped.
+// hasID3v1 reports whether the stream of the given size ends with an ID3v1 tag.
+func hasID3v1(r io.ReadSeeker, size int64) bool {
+	if size < id3v1Size {
+		return false
+	}
+	if _, err := r.Seek(size-id3v1Size, io.SeekStart); err != nil {
+		return false
+	}
+	marker := make([]byte, 3)
+	_, err := io.ReadFull(r, marker)
+	return err == nil && string(marker) == "TAG"
+}
+
+func parseID3v2(tag []byte) map[string]string {
+	tags := make(map[string]string)
+	version, flags := tag[3], tag[5]
+	body := tag[id3HeaderSize:]
+	if version < 4 && flags&id3FlagUnsync != 0 {
+		body = removeID3Unsync(body)
+	}
+	if flags&id3FlagExtended != 0 && version >= 3 && len(body) >= 4 {
+		extSize := int(binary.BigEndian.Uint32(body))
+		if version == 4 {
+			extSize = id3SyncSafe(body)
+		} else {
+			extSize += 4
+		}
+		if extSize > len(body) {
+			return tags
+		}
+		body = body[extSize:]
+	}
+
+	idLen, headerLen, names := 4, 10, id3TextFrames
+	if version == 2 {
+		idLen, headerLen, names = 3, 6, id3v22TextFrames
+	}
+	for len(body) >= headerLen && body[0] != 0 {
+		id := string(body[:idLen])
+		var size int
+		opaque, unsync, hasLength := false, false, false
+		switch version {
+		case 2:
+			size = int(body[3])<<16 | int(body[4])<<8 | int(body[5])
+		case 3:
+			size = int(binary.BigEndian.Uint32(body[4:8]))
+			opaque = body[9]&id3v23FrameFlagsOpaque != 0
+		default:
+			size = id3SyncSafe(body[4:8])
+			opaque = body[9]&id3v24FrameFlagsOpaque != 0
+			unsync = body[9]&id3v24FrameFlagUnsync != 0
+			hasLength = body[9]&id3v24FrameFlagLength != 0
+		}
+		if size < 0 || headerLen+size > len(body) {
+			break
+		}
+		data := body[headerLen : headerLen+size]
+		body = body[headerLen+size:]
+
+		name, ok := names[id]
+		if !ok || opaque {
+			continue
+		}
+		if hasLength {
+			if len(data) < 4 {
+				continue
+			}
+			data = data[4:]
+		}
+		if unsync {
+			data = removeID3Unsync(data)
+		}
+		for _, value := range decodeID3Text(data) {
+			addTag(tags, name, value)
+		}
+	}
+	return tags
+}
+
+// decodeID3Text decodes the body of a text frame; ID3v2.4 separates multiple values with NUL.
+func decodeID3Text(data []byte) []string {
+	if len(data) == 0 {
+		return nil
+	}
+	var text string
+	switch encoding, b := data[0], data[1:]; encoding {
+	case 0:
+		runes := make([]rune, len(b))
+		for i, c := range b {
+			runes[i] = rune(c)
+		}
+		text = string(runes)
+	case 1, 2:
+		bigEndian := encoding == 2
+		if len(b) >= 2 && (b[0] == 0xFE && b[1] == 0xFF || b[0] == 0xFF && b[1] == 0xFE) {
+			bigEndian = b[0] == 0xFE
+			b = b[2:]
+		}
+		units := make([]uint16, len(b)/2)
+		for i := range units {
+			if bigEndian {
+				units[i] = binary.BigEndian.Uint16(b[i*2:])
+			} else {
+				units[i] = binary.LittleEndian.Uint16(b[i*2:])
+			}
+		}
+		text = string(utf16.Decode(units))
+	case 3:
+		text = string(b)
+	default:
+		return nil
+	}
+	var values []string
+	for _, value := range strings.Split(strings.TrimRight(text, "\x00"), "\x00") {
+		// A UTF-16 value after a separator may start with its own BOM.
+		if value = strings.TrimSpace(strings.TrimPrefix(value, "\ufeff")); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// removeID3Unsync undoes the unsynchronisation scheme, which inserts 0x00 after every 0xFF.
+func removeID3Unsync(b []byte) []byte {
+	out := make([]byte, 0, len(b))
+	for i := 0; i < len(b); i++ {
+		out = append(out, b[i])
+		if b[i] == 0xFF && i+1 < len(b) && b[i+1] == 0x00 {
+			i++
+		}
+	}
+	return out
+}
+
+func id3SyncSafe(b []byte) int {
+	return int(b[0]&0x7F)<<21 | int(b[1]&0x7F)<<14 | int(b[2]&0x7F)<<7 | int(b[3]&0x7F)
+}
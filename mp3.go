@@ -0,0 +1,474 @@
+package goshazam
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/hajimehoshi/go-mp3"
+	"io"
+	"math"
+	"time"
+)
+
+// go-mp3 always produces interleaved 16-bit little-endian stereo.
+const mp3BytesPerFrame = 4
+
+// MP3Decoder is the native Decoder for MPEG-1/2/2.5 Layer III streams.
+type MP3Decoder struct{}
+
+func (MP3Decoder) Decode(_ context.Context, r io.Reader, opts DecodeOptions) ([]int16, error) {
+	return DecodeMP3(r, opts)
+}
+
+// DecodeStream decodes the selected window of r while it is read.
+func (MP3Decoder) DecodeStream(_ context.Context, r io.Reader, opts DecodeOptions) (io.ReadCloser, error) {
+	return decodeMP3Stream(r, opts)
+}
+
+// DecodeLive decodes an MP3 stream while it arrives. The stream may start in
+// the middle of a frame, as radio streams joined at an arbitrary point do.
+func (MP3Decoder) DecodeLive(_ context.Context, r io.Reader) (io.ReadCloser, error) {
+	// Hide Seek so go-mp3 does not try to index the stream.
+	dec, err := mp3.NewDecoder(struct{ io.Reader }{r})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open MP3 stream: %w", err)
+	}
+	return convertMP3(dec, dec), nil
+}
+
+func (MP3Decoder) Probe(_ context.Context, path string) (*MediaInfo, error) {
+	return probeFile(path, probeMP3)
+}
+
+// probeMP3 reads the ID3v2 tag and the first frame. The duration is the frame count
+// of the Xing, Info or VBRI header that encoders write into the first frame, or, as
+// ffprobe estimates it without one, the length of the stream at the first frame's
+// bitrate; either way the frames themselves are not read. The bitrate is the average.
+func probeMP3(r io.ReadSeeker, size int64) (*MediaInfo, error) {
+	tags, id3Size, err := readID3v2(r)
+	if err != nil {
+		return nil, err
+	}
+	head := make([]byte, mp3XingHeaderSize)
+	n, err := io.ReadFull(r, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, fmt.Errorf("failed to read MP3 frame header: %w", err)
+	}
+	head = head[:n]
+	h, ok := parseMP3FrameHeader(head)
+	if !ok {
+		return nil, fmt.Errorf("failed to open MP3 stream: no frame after ID3v2 tag")
+	}
+
+	audioSize := size - id3Size
+	if hasID3v1(r, size) {
+		audioSize -= id3v1Size
+	}
+	var duration time.Duration
+	if frames, ok := parseMP3InfoFrame(head, h); ok && frames > 0 {
+		duration = framesDuration(frames*int64(h.samplesPerFrame), h.sampleRate)
+	} else {
+		duration = time.Duration(float64(audioSize*8) / float64(h.bitrate) * float64(time.Second))
+	}
+	return &MediaInfo{
+		Duration:   duration,
+		Container:  "mp3",
+		Codec:      "mp3",
+		SampleRate: h.sampleRate,
+		Channels:   h.channels,
+		Bitrate:    averageBitrate(audioSize, duration),
+		Tags:       tags,
+	}, nil
+}
+
+// IsMP3 reports whether header starts with a Layer III frame, optionally
+// preceded by an ID3v2 tag that fits in header.
+func IsMP3(header []byte) bool {
+	i := skipID3v2Header(header)
+	if i+2 > len(header) {
+		return false
+	}
+	// 11 sync bits, then any MPEG version and layer bits 01 (Layer III).
+	return header[i] == 0xFF && header[i+1]&0xE0 == 0xE0 && header[i+1]>>1&0x3 == 0x1
+}
+
+// DecodeMP3 decodes the selected window of an MP3 stream into 16 kHz mono
+// samples. When r is an io.ReadSeeker, the stream is entered close to the offset
+// as ffmpeg does: by position for constant bitrate streams and through the Xing
+// seek table for variable bitrate ones. Other streams are decoded from the start
+// and the audio before the offset is discarded. Like ffmpeg, it leaves out the
+// information frame that encoders put before the audio, which is no audio itself.
+func DecodeMP3(r io.Reader, opts DecodeOptions) ([]int16, error) {
+	pcm, err := decodeMP3Stream(r, opts)
+	if err != nil {
+		return nil, err
+	}
+	return readDecoded(pcm)
+}
+
+func decodeMP3Stream(r io.Reader, opts DecodeOptions) (*pcmStream, error) {
+	if err := opts.checkSingleStream(); err != nil {
+		return nil, err
+	}
+	skipped, seeked := int64(0), false
+	if seeker, ok := r.(io.ReadSeeker); ok && opts.Offset > 0 {
+		landed, ok, err := seekMP3(seeker, opts.Offset)
+		if err != nil {
+			return nil, fmt.Errorf("failed to seek MP3 stream: %w", err)
+		}
+		skipped, seeked = landed, ok
+	}
+	if !seeked {
+		br := bufio.NewReaderSize(r, sniffLen)
+		if err := skipMP3Head(br); err != nil {
+			return nil, err
+		}
+		r = br
+	}
+
+	// Hide Seek: go-mp3 indexes the whole stream as soon as it can seek, which
+	// reads all of it, e.g. downloads a whole remote file.
+	dec, err := mp3.NewDecoder(struct{ io.Reader }{r})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open MP3 stream: %w", err)
+	}
+
+	startFrame, frameCount := opts.frames(dec.SampleRate())
+	if skip := (startFrame - skipped) * mp3BytesPerFrame; skip > 0 {
+		if _, err := io.CopyN(io.Discard, dec, skip); err != nil && err != io.EOF {
+			return nil, fmt.Errorf("failed to skip MP3 audio: %w", err)
+		}
+	}
+	var out io.Reader = dec
+	if frameCount > 0 {
+		out = io.LimitReader(dec, frameCount*mp3BytesPerFrame)
+	}
+	return convertMP3(dec, out), nil
+}
+
+// convertMP3 converts the 16-bit stereo PCM that dec decodes, read from out, to 16 kHz mono.
+func convertMP3(dec *mp3.Decoder, out io.Reader) *pcmStream {
+	blocks := newPCMBlocks(out, PCMFormat{SampleFormat: SampleFormatS16LE, Channels: 2, SampleRate: dec.SampleRate()})
+	// go-mp3 only produces the sample rates of MPEG audio and always two channels.
+	stream, _ := newPCMStream(func() ([]float64, error) {
+		block, err := blocks.next()
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("failed to decode MP3 stream: %w", err)
+		}
+		return block, err
+	}, dec.SampleRate(), 2)
+	return stream
+}
+
+// skipMP3Head advances br past the ID3v2 tag and the information frame of a stream
+// read from its start, so that the first frame decoded is the first frame of audio.
+// Decoders that do not know information frames output them as a frame of silence.
+func skipMP3Head(br *bufio.Reader) error {
+	header, _ := br.Peek(id3HeaderSize)
+	if _, err := br.Discard(skipID3v2Header(header)); err != nil {
+		return fmt.Errorf("failed to skip ID3v2 tag: %w", err)
+	}
+	frame, _ := br.Peek(mp3XingHeaderSize)
+	if h, ok := parseMP3FrameHeader(frame); ok {
+		if _, ok := parseMP3InfoFrame(frame, h); ok {
+			if _, err := br.Discard(h.length()); err != nil {
+				return fmt.Errorf("failed to skip MP3 information frame: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// mp3FrameHeader holds the fields of an MPEG-1/2/2.5 Layer III frame header.
+type mp3FrameHeader struct {
+	mpeg1           bool
+	sampleRate      int
+	bitrate         int // bits per second
+	padding         int
+	channels        int
+	samplesPerFrame int
+}
+
+var (
+	mp3Bitrates       = [2][15]int{{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160}, {0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320}}
+	mp3SampleRates    = [3]int{44100, 48000, 32000}
+	mp3VBRTagOffsets  = [2][2]int{{9, 17}, {17, 32}} // side info size by MPEG-1 and channel count
+	mp3MaxReservoir   = 511
+	mp3SyncSearchSize = 8192
+	// mp3XingHeaderSize covers the side info and a Xing header with its seek table.
+	mp3XingHeaderSize = 4 + 32 + 4 + 4 + 4 + 4 + 100
+)
+
+// parseMP3FrameHeader decodes the four header bytes of a Layer III frame.
+func parseMP3FrameHeader(b []byte) (mp3FrameHeader, bool) {
+	if len(b) < 4 || !IsMP3(b[:2]) {
+		return mp3FrameHeader{}, false
+	}
+	version := b[1] >> 3 & 0x3 // 0: MPEG-2.5, 2: MPEG-2, 3: MPEG-1
+	bitrateIndex := b[2] >> 4
+	rateIndex := b[2] >> 2 & 0x3
+	if version == 1 || bitrateIndex == 0 || bitrateIndex == 15 || rateIndex == 3 {
+		return mp3FrameHeader{}, false
+	}
+	h := mp3FrameHeader{
+		mpeg1:           version == 3,
+		sampleRate:      mp3SampleRates[rateIndex],
+		padding:         int(b[2] >> 1 & 0x1),
+		channels:        2,
+		samplesPerFrame: 576,
+	}
+	table := 0
+	if h.mpeg1 {
+		table, h.samplesPerFrame = 1, 1152
+	} else {
+		h.sampleRate /= 2
+		if version == 0 {
+			h.sampleRate /= 2
+		}
+	}
+	h.bitrate = mp3Bitrates[table][bitrateIndex] * 1000
+	if b[3]>>6 == 3 {
+		h.channels = 1
+	}
+	return h, true
+}
+
+// frameSize returns the average frame length in bytes, which padding spreads over the frames.
+func (h mp3FrameHeader) frameSize() float64 {
+	return float64(h.samplesPerFrame/8*h.bitrate) / float64(h.sampleRate)
+}
+
+// length returns the length in bytes of the frame with this header.
+func (h mp3FrameHeader) length() int {
+	return int(h.frameSize()) + h.padding
+}
+
+// seekMP3 positions r on a frame shortly before offset, leaving enough frames
+// before it to refill the bit reservoir, and returns the index of the sample frame
+// decoding resumes at, counted from the first frame of audio after any information
+// frame. Constant bitrate streams are entered by position, variable
+// bitrate streams through their Xing seek table. It restores the position of r and
+// reports false for variable bitrate streams without one.
+func seekMP3(r io.ReadSeeker, offset time.Duration) (int64, bool, error) {
+	start, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, false, err
+	}
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(r, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return 0, false, err
+	}
+	head = head[:n]
+	first := int64(skipID3v2Header(head))
+	// The tag may be larger than what was read.
+	if _, err := r.Seek(start+first, io.SeekStart); err != nil {
+		return 0, false, err
+	}
+	n, err = io.ReadFull(r, head[:min(len(head), mp3XingHeaderSize)])
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return 0, false, restoreSeek(r, start, err)
+	}
+	head = head[:n]
+	h, ok := parseMP3FrameHeader(head)
+	if !ok {
+		return 0, false, restoreSeek(r, start, nil)
+	}
+	// An information frame before the audio is not counted as audio.
+	audio := start + first
+	if _, ok := parseMP3InfoFrame(head, h); ok {
+		audio += int64(h.length())
+	}
+	if isMP3VBR(head, h) {
+		xing, ok := parseMP3Xing(head, h)
+		if !ok {
+			return 0, false, restoreSeek(r, start, nil)
+		}
+		return seekMP3Xing(r, start, start+first, audio, offset, h, xing)
+	}
+
+	frameSize := h.frameSize()
+	preroll := int64(math.Ceil(float64(mp3MaxReservoir)/frameSize)) + 1
+	frame := max(int64(offset.Seconds()*float64(h.sampleRate))/int64(h.samplesPerFrame)-preroll, 0)
+	pos := audio + int64(float64(frame)*frameSize)
+	// Padding makes frame starts drift by a byte either way, so look for the sync word just before.
+	synced, err := syncMP3(r, max(pos-4, audio))
+	if err != nil {
+		return 0, false, restoreSeek(r, start, err)
+	}
+	frame = int64(math.Round(float64(synced-audio) / frameSize))
+	return frame * int64(h.samplesPerFrame), true, nil
+}
+
+// mp3Xing is the part of a Xing header needed to seek: the stream's length in
+// frames and bytes, and toc, where toc[i] is the position at i% of the duration
+// in 256ths of the length.
+type mp3Xing struct {
+	frames int64
+	bytes  int64
+	toc    [100]byte
+}
+
+// parseMP3Xing reads the Xing header of the first frame, which must have a frame
+// count, a byte count and a seek table.
+func parseMP3Xing(frame []byte, h mp3FrameHeader) (mp3Xing, bool) {
+	mpeg1, mono := 0, 1
+	if h.mpeg1 {
+		mpeg1 = 1
+	}
+	if h.channels == 1 {
+		mono = 0
+	}
+	i := 4 + mp3VBRTagOffsets[mpeg1][mono]
+	if len(frame) < i+8 || string(frame[i:i+4]) != "Xing" {
+		return mp3Xing{}, false
+	}
+	flags := binary.BigEndian.Uint32(frame[i+4:])
+	if flags&0x7 != 0x7 || len(frame) < i+8+4+4+100 {
+		return mp3Xing{}, false
+	}
+	x := mp3Xing{
+		frames: int64(binary.BigEndian.Uint32(frame[i+8:])),
+		bytes:  int64(binary.BigEndian.Uint32(frame[i+12:])),
+	}
+	copy(x.toc[:], frame[i+16:])
+	return x, x.frames > 0 && x.bytes > 0
+}
+
+// position returns the byte position, from the first frame, of the given fraction
+// of the duration. The table's entries are rounded down, so they are read as the
+// middle of the 256th they stand for.
+func (x mp3Xing) position(fraction float64) int64 {
+	percent := min(max(fraction, 0), 1) * 100
+	i := min(int(percent), 99)
+	a, b := x.entry(i), x.entry(i+1)
+	return int64((a + (b-a)*(percent-float64(i))) / 256 * float64(x.bytes))
+}
+
+// fraction is the inverse of position.
+func (x mp3Xing) fraction(pos int64) float64 {
+	f := float64(pos) * 256 / float64(x.bytes)
+	i := 0
+	for i < 99 && x.entry(i+1) <= f {
+		i++
+	}
+	a, b := x.entry(i), x.entry(i+1)
+	percent := float64(i)
+	if b > a {
+		percent += min(max((f-a)/(b-a), 0), 1)
+	}
+	return percent / 100
+}
+
+// entry returns the position at i% of the duration in 256ths of the length.
+func (x mp3Xing) entry(i int) float64 {
+	switch {
+	case i == 0:
+		return 0
+	case i >= 100:
+		return 256
+	}
+	return float64(x.toc[i]) + 0.5
+}
+
+// seekMP3Xing is seekMP3 for a variable bitrate stream that starts at start, whose
+// first frame, the one with the Xing header, is at first and whose audio starts at audio.
+// The seek table has a resolution of 1% of the duration, so like ffmpeg's the position
+// is approximate.
+func seekMP3Xing(r io.ReadSeeker, start, first, audio int64, offset time.Duration, h mp3FrameHeader, x mp3Xing) (int64, bool, error) {
+	frameDuration := float64(h.samplesPerFrame) / float64(h.sampleRate)
+	preroll := math.Ceil(float64(mp3MaxReservoir)*float64(x.frames)/float64(x.bytes)) + 1
+	target := offset.Seconds() - preroll*frameDuration
+	if target <= 0 {
+		if _, err := r.Seek(audio, io.SeekStart); err != nil {
+			return 0, false, restoreSeek(r, start, err)
+		}
+		return 0, true, nil
+	}
+	fraction := target / (float64(x.frames) * frameDuration)
+	synced, err := syncMP3(r, first+x.position(fraction))
+	if err != nil {
+		return 0, false, restoreSeek(r, start, err)
+	}
+	frame := int64(math.Round(x.fraction(synced-first) * float64(x.frames)))
+	return frame * int64(h.samplesPerFrame), true, nil
+}
+
+// restoreSeek returns r to pos and passes err through.
+func restoreSeek(r io.Seeker, pos int64, err error) error {
+	if _, seekErr := r.Seek(pos, io.SeekStart); seekErr != nil && err == nil {
+		err = seekErr
+	}
+	return err
+}
+
+// isMP3VBR reports whether the first frame carries a Xing or VBRI header. LAME marks
+// constant bitrate files with an "Info" header instead.
+func isMP3VBR(frame []byte, h mp3FrameHeader) bool {
+	mpeg1, mono := 0, 1
+	if h.mpeg1 {
+		mpeg1 = 1
+	}
+	if h.channels == 1 {
+		mono = 0
+	}
+	if i := 4 + mp3VBRTagOffsets[mpeg1][mono]; len(frame) >= i+4 && string(frame[i:i+4]) == "Xing" {
+		return true
+	}
+	return len(frame) >= 40 && string(frame[36:40]) == "VBRI"
+}
+
+// parseMP3InfoFrame reports whether the first frame is an information frame, one with a
+// Xing, Info or VBRI header instead of audio, and returns the number of audio frames it
+// declares, or 0 when it declares none.
+func parseMP3InfoFrame(frame []byte, h mp3FrameHeader) (int64, bool) {
+	mpeg1, mono := 0, 1
+	if h.mpeg1 {
+		mpeg1 = 1
+	}
+	if h.channels == 1 {
+		mono = 0
+	}
+	if i := 4 + mp3VBRTagOffsets[mpeg1][mono]; len(frame) >= i+8 && (string(frame[i:i+4]) == "Xing" || string(frame[i:i+4]) == "Info") {
+		if binary.BigEndian.Uint32(frame[i+4:])&0x1 == 0 || len(frame) < i+12 {
+			return 0, true
+		}
+		return int64(binary.BigEndian.Uint32(frame[i+8:])), true
+	}
+	// "VBRI", version, delay and quality, the byte count and then the frame count.
+	if len(frame) >= 36+18 && string(frame[36:40]) == "VBRI" {
+		return int64(binary.BigEndian.Uint32(frame[36+14:])), true
+	}
+	return 0, false
+}
+
+// syncMP3 finds the first frame header at or after pos that is followed by
+// another one where its length says, seeks r there and returns its position.
+func syncMP3(r io.ReadSeeker, pos int64) (int64, error) {
+	if _, err := r.Seek(pos, io.SeekStart); err != nil {
+		return 0, err
+	}
+	buf := make([]byte, mp3SyncSearchSize)
+	n, err := io.ReadFull(r, buf)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return 0, err
+	}
+	buf = buf[:n]
+	for i := 0; i+4 <= len(buf); i++ {
+		h, ok := parseMP3FrameHeader(buf[i:])
+		if !ok {
+			continue
+		}
+		next := i + h.length()
+		if next+4 <= len(buf) {
+			if _, ok := parseMP3FrameHeader(buf[next:]); !ok {
+				continue
+			}
+		}
+		_, err := r.Seek(pos+int64(i), io.SeekStart)
+		return pos + int64(i), err
+	}
+	return 0, fmt.Errorf("no MP3 frame found at byte %d", pos)
+}
@@ -0,0 +1,136 @@
+package goshazam
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// speechMP3 is 320 frames of a constant bitrate MPEG-2 stream: 22.05 kHz mono at 48 kb/s.
+const speechMP3Frames = 320
+
+func readSpeechMP3(t *testing.T) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "speech.mp3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// withMP3InfoFrame returns data preceded by an information frame with an Info header
+// declaring frames audio frames, as LAME writes one before constant bitrate audio.
+func withMP3InfoFrame(t *testing.T, data []byte, frames uint32) []byte {
+	t.Helper()
+	h, ok := parseMP3FrameHeader(data)
+	if !ok {
+		t.Fatal("no MP3 frame header")
+	}
+	h.padding = 0
+	frame := make([]byte, h.length())
+	copy(frame, data[:4])
+	frame[2] &^= 0x2 // no padding
+	tag := frame[4+mp3VBRTagOffsets[0][0]:]
+	copy(tag, "Info")
+	binary.BigEndian.PutUint32(tag[4:], 0x1)
+	binary.BigEndian.PutUint32(tag[8:], frames)
+	return append(frame, data...)
+}
+
+// readOnly hides every method of r but Read.
+func readOnly(r io.Reader) io.Reader {
+	return struct{ io.Reader }{r}
+}
+
+func TestDecodeMP3SkipsInfoFrame(t *testing.T) {
+	data := readSpeechMP3(t)
+	tagged := withMP3InfoFrame(t, data, speechMP3Frames)
+	for _, offset := range []time.Duration{0, 3 * time.Second} {
+		opts := DecodeOptions{Offset: offset, Duration: 4 * time.Second}
+		want, err := DecodeMP3(bytes.NewReader(data), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, r := range map[string]io.Reader{
+			"seekable": bytes.NewReader(tagged),
+			"stream":   readOnly(bytes.NewReader(tagged)),
+		} {
+			got, err := DecodeMP3(r, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, want) {
+				t.Errorf("%s at %v: decoding with an information frame differs from decoding without", name, offset)
+			}
+		}
+	}
+}
+
+// TestDecodeMP3SeekMatchesStream checks that entering the stream by position yields the
+// same audio as decoding it from the start, not audio a frame early or late.
+func TestDecodeMP3SeekMatchesStream(t *testing.T) {
+	data := withMP3InfoFrame(t, readSpeechMP3(t), speechMP3Frames)
+	opts := DecodeOptions{Offset: 4 * time.Second, Duration: 3 * time.Second}
+	seeked, err := DecodeMP3(bytes.NewReader(data), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	streamed, err := DecodeMP3(readOnly(bytes.NewReader(data)), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seeked) != len(streamed) {
+		t.Fatalf("seeking decoded %d samples, streaming %d", len(seeked), len(streamed))
+	}
+	// The resampler's filter starts up differently, so leave out its length.
+	if ratio := snr(seeked, int16ToFloat(streamed), resampleFilterSize); ratio < 60 {
+		t.Errorf("SNR of the seeked audio against the streamed audio is %.1f dB, want at least 60 dB", ratio)
+	}
+}
+
+// countingReadSeeker counts the bytes read through it.
+type countingReadSeeker struct {
+	io.ReadSeeker
+	read int64
+}
+
+func (c *countingReadSeeker) Read(p []byte) (int, error) {
+	n, err := c.ReadSeeker.Read(p)
+	c.read += int64(n)
+	return n, err
+}
+
+func TestProbeMP3(t *testing.T) {
+	data := readSpeechMP3(t)
+	frameDuration := 576 * time.Second / 22050
+	for _, c := range []struct {
+		name string
+		data []byte
+		want time.Duration
+	}{
+		// Without an information frame the duration follows from the size and the bitrate.
+		{"estimated", data, time.Duration(float64(len(data)*8) / 48000 * float64(time.Second))},
+		{"info frame", withMP3InfoFrame(t, data, speechMP3Frames), speechMP3Frames * frameDuration},
+	} {
+		r := &countingReadSeeker{ReadSeeker: bytes.NewReader(c.data)}
+		info, err := probeMP3(r, int64(len(c.data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(float64(info.Duration-c.want)) > float64(time.Millisecond) {
+			t.Errorf("%s: duration is %v, want %v", c.name, info.Duration, c.want)
+		}
+		if info.SampleRate != 22050 || info.Channels != 1 {
+			t.Errorf("%s: got %d Hz and %d channels, want 22050 Hz mono", c.name, info.SampleRate, info.Channels)
+		}
+		if r.read > sniffLen {
+			t.Errorf("%s: probing read %d bytes, want the frame header only", c.name, r.read)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package goshazam
+
+import (
+	"gonum.org/v1/gonum/dsp/fourier"
+	"math"
+	"sort"
+)
+
+const (
+	noiseFrameSize = 512 // 32 ms at 16 kHz
+	noiseHopSize   = noiseFrameSize / 2
+	// noisePercentile selects the per-bin power taken as the noise floor. Music rarely
+	// covers a bin all the time, so a low percentile mostly sees noise.
+	noisePercentile = 0.2
+	// noiseOverSubtraction removes more than the estimated floor, since the floor of
+	// fluctuating noise is exceeded about as often as not.
+	noiseOverSubtraction = 2.0
+)
+
+// noiseWindow is a periodic Hann window; at 50% overlap the shifted windows sum to one,
+// so overlap-adding the processed frames rebuilds the signal without extra scaling.
+var noiseWindow = func() []float64 {
+	w := make([]float64, noiseFrameSize)
+	for i := range w {
+		w[i] = 0.5 * (1 - math.Cos(2*math.Pi*float64(i)/noiseFrameSize))
+	}
+	return w
+}()
+
+// ReduceNoise is a spectral subtraction stage for steady broadband noise such as
+// crowd, traffic or engine noise. It estimates the noise floor of every frequency
+// bin from the input itself and attenuates the parts of the spectrum that do not
+// rise above it by up to reductionDB, e.g. 20, acting as a per-bin noise gate.
+func ReduceNoise(reductionDB float64) Stage {
+	minGain := dbToAmplitude(-math.Abs(reductionDB))
+
+	return func(samples []float64) []float64 {
+		if len(samples) == 0 {
+			return samples
+		}
+		fft := fourier.NewFFT(noiseFrameSize)
+		frame := make([]float64, noiseFrameSize)
+
+		// Pad so that every sample is covered by two frames.
+		frames := (len(samples)+noiseHopSize-1)/noiseHopSize + 1
+		padded := make([]float64, (frames+1)*noiseHopSize)
+		copy(padded[noiseHopSize:], samples)
+
+		spectra := make([][]complex128, frames)
+		for f := range spectra {
+			for i, v := range padded[f*noiseHopSize : f*noiseHopSize+noiseFrameSize] {
+				frame[i] = v * noiseWindow[i]
+			}
+			spectra[f] = fft.Coefficients(nil, frame)
+		}
+		noise := estimateNoisePower(spectra)
+
+		out := make([]float64, len(padded))
+		for f, spectrum := range spectra {
+			for bin, c := range spectrum {
+				power := real(c)*real(c) + imag(c)*imag(c)
+				gain := minGain
+				if power > 0 {
+					gain = math.Max(minGain, math.Sqrt(math.Max(0, 1-noiseOverSubtraction*noise[bin]/power)))
+				}
+				spectrum[bin] = c * complex(gain, 0)
+			}
+			fft.Sequence(frame, spectrum)
+			for i, v := range frame {
+				// Sequence does not normalize the inverse transform.
+				out[f*noiseHopSize+i] += v / noiseFrameSize
+			}
+		}
+		copy(samples, out[noiseHopSize:])
+		return samples
+	}
+}
+
+// estimateNoisePower returns the noise power of every bin: a low percentile of the bin's
+// power over all frames, scaled to the mean power of noise whose power is exponentially
+// distributed, as it is for Gaussian noise.
+func estimateNoisePower(spectra [][]complex128) []float64 {
+	bins := len(spectra[0])
+	noise := make([]float64, bins)
+	powers := make([]float64, len(spectra))
+	index := int(noisePercentile * float64(len(spectra)-1))
+	bias := -1 / math.Log(1-noisePercentile)
+	for bin := range noise {
+		for f, spectrum := range spectra {
+			c := spectrum[bin]
+			powers[f] = real(c)*real(c) + imag(c)*imag(c)
+		}
+		sort.Float64s(powers)
+		noise[bin] = powers[index] * bias
+	}
+	return noise
+}
@@ -0,0 +1,216 @@
+package goshazam
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	oggHeaderSize     = 27
+	oggFlagContinued  = 0x01
+	oggFlagBOS        = 0x02
+	oggFlagEOS        = 0x04
+	oggNoGranule      = -1
+	oggMaxPacketBytes = 16 << 20
+	// oggMaxPageBytes bounds a page: the header, 255 lacing values and 255 full segments.
+	oggMaxPageBytes = oggHeaderSize + 255 + 255*255
+)
+
+// ErrInvalidOgg is returned when the input is not a well-formed Ogg stream.
+var ErrInvalidOgg = errors.New("invalid Ogg data")
+
+var oggCRCTable = makeCRC32Table(0x04C11DB7)
+
+// IsOgg reports whether header starts with an Ogg page.
+func IsOgg(header []byte) bool {
+	return bytes.HasPrefix(header, []byte("OggS"))
+}
+
+// oggPacket is one packet of a logical bitstream. Granule is the granule
+// position of the page the packet completes on when it is the last packet
+// completed there, and oggNoGranule otherwise.
+type oggPacket struct {
+	data    []byte
+	granule int64
+	eos     bool
+}
+
+// oggReader reassembles the packets of a single logical bitstream. The stream
+// is chosen by accept, which sees the first packet of every BOS page.
+type oggReader struct {
+	r       *bufio.Reader
+	accept  func(first []byte) bool
+	serial  uint32
+	locked  bool
+	partial []byte
+	queue   []oggPacket
+	eos     bool
+	header  [oggHeaderSize]byte
+}
+
+func newOggReader(r io.Reader, accept func(first []byte) bool) *oggReader {
+	return &oggReader{r: bufio.NewReader(r), accept: accept}
+}
+
+// nextPacket returns the next complete packet, or io.EOF after the last one.
+func (o *oggReader) nextPacket() (oggPacket, error) {
+	for len(o.queue) == 0 {
+		if o.eos {
+			return oggPacket{}, io.EOF
+		}
+		if err := o.readPage(); err != nil {
+			return oggPacket{}, err
+		}
+	}
+	p := o.queue[0]
+	o.queue = o.queue[1:]
+	return p, nil
+}
+
+func (o *oggReader) readPage() error {
+	if _, err := io.ReadFull(o.r, o.header[:]); err != nil {
+		return o.truncated(err)
+	}
+	h := o.header[:]
+	if string(h[0:4]) != "OggS" || h[4] != 0 {
+		return fmt.Errorf("%w: lost page sync", ErrInvalidOgg)
+	}
+	flags := h[5]
+	granule := int64(binary.LittleEndian.Uint64(h[6:14]))
+	serial := binary.LittleEndian.Uint32(h[14:18])
+	checksum := binary.LittleEndian.Uint32(h[22:26])
+
+	lacing := make([]byte, h[26])
+	if _, err := io.ReadFull(o.r, lacing); err != nil {
+		return o.truncated(err)
+	}
+	bodySize := 0
+	for _, l := range lacing {
+		bodySize += int(l)
+	}
+	body := make([]byte, bodySize)
+	if _, err := io.ReadFull(o.r, body); err != nil {
+		return o.truncated(err)
+	}
+
+	crc := uint32(0)
+	for i, b := range h {
+		if i >= 22 && i < 26 {
+			b = 0
+		}
+		crc = crc<<8 ^ oggCRCTable[byte(crc>>24)^b]
+	}
+	for _, b := range lacing {
+		crc = crc<<8 ^ oggCRCTable[byte(crc>>24)^b]
+	}
+	for _, b := range body {
+		crc = crc<<8 ^ oggCRCTable[byte(crc>>24)^b]
+	}
+	if crc != checksum {
+		return fmt.Errorf("%w: page CRC mismatch", ErrInvalidOgg)
+	}
+
+	if !o.locked {
+		if flags&oggFlagBOS == 0 || !o.accept(firstOggPacket(lacing, body)) {
+			return nil
+		}
+		o.serial, o.locked = serial, true
+	}
+	if serial != o.serial {
+		return nil
+	}
+	if flags&oggFlagContinued == 0 {
+		o.partial = o.partial[:0]
+	}
+
+	completed := len(o.queue)
+	offset := 0
+	for _, l := range lacing {
+		o.partial = append(o.partial, body[offset:offset+int(l)]...)
+		offset += int(l)
+		if len(o.partial) > oggMaxPacketBytes {
+			return fmt.Errorf("%w: packet too large", ErrInvalidOgg)
+		}
+		if l < 255 {
+			o.queue = append(o.queue, oggPacket{data: append([]byte(nil), o.partial...), granule: oggNoGranule})
+			o.partial = o.partial[:0]
+		}
+	}
+	if len(o.queue) > completed {
+		o.queue[len(o.queue)-1].granule = granule
+	}
+	if flags&oggFlagEOS != 0 {
+		if len(o.queue) > 0 {
+			o.queue[len(o.queue)-1].eos = true
+		}
+		o.eos = true
+	}
+	return nil
+}
+
+// oggLastGranule returns the highest granule position of the given stream
+// found in the pages at the end of r, or 0 when there is none.
+func oggLastGranule(r io.ReadSeeker, size int64, serial uint32) (int64, error) {
+	start := max(size-2*oggMaxPageBytes, 0)
+	if _, err := r.Seek(start, io.SeekStart); err != nil {
+		return 0, err
+	}
+	tail, err := io.ReadAll(r)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read the last Ogg pages: %w", err)
+	}
+
+	last := int64(0)
+	for i := 0; i+oggHeaderSize <= len(tail); i++ {
+		h := tail[i:]
+		if string(h[0:4]) != "OggS" || h[4] != 0 || binary.LittleEndian.Uint32(h[14:18]) != serial {
+			continue
+		}
+		if granule := int64(binary.LittleEndian.Uint64(h[6:14])); granule > last {
+			last = granule
+		}
+	}
+	return last, nil
+}
+
+// truncated ends the stream when err reports that the input ran out, and returns other errors.
+func (o *oggReader) truncated(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		// A stream cut without an EOS page simply ends.
+		o.eos = true
+		return nil
+	}
+	return err
+}
+
+// firstOggPacket returns the first packet (or its part) on a page.
+func firstOggPacket(lacing, body []byte) []byte {
+	size := 0
+	for _, l := range lacing {
+		size += int(l)
+		if l < 255 {
+			break
+		}
+	}
+	return body[:size]
+}
+
+func makeCRC32Table(poly uint32) [256]uint32 {
+	var table [256]uint32
+	for i := range table {
+		crc := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ poly
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}
@@ -0,0 +1,208 @@
+package goshazam
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// SampleFormat is the encoding of a single raw PCM sample.
+type SampleFormat int
+
+const (
+	SampleFormatU8 SampleFormat = iota + 1
+	SampleFormatS16LE
+	SampleFormatS16BE
+	SampleFormatS24LE // packed into 3 bytes
+	SampleFormatS24BE // packed into 3 bytes
+	SampleFormatS32LE
+	SampleFormatS32BE
+	SampleFormatF32LE
+	SampleFormatF32BE
+	SampleFormatF64LE
+	SampleFormatF64BE
+)
+
+// ErrInvalidPCMFormat is returned when a PCMFormat cannot describe any input.
+var ErrInvalidPCMFormat = errors.New("invalid PCM format")
+
+var sampleFormatNames = map[SampleFormat]string{
+	SampleFormatU8:    "u8",
+	SampleFormatS16LE: "s16le",
+	SampleFormatS16BE: "s16be",
+	SampleFormatS24LE: "s24le",
+	SampleFormatS24BE: "s24be",
+	SampleFormatS32LE: "s32le",
+	SampleFormatS32BE: "s32be",
+	SampleFormatF32LE: "f32le",
+	SampleFormatF32BE: "f32be",
+	SampleFormatF64LE: "f64le",
+	SampleFormatF64BE: "f64be",
+}
+
+// String returns ffmpeg's name for the format, e.g. "s16le".
+func (f SampleFormat) String() string {
+	if name, ok := sampleFormatNames[f]; ok {
+		return name
+	}
+	return fmt.Sprintf("SampleFormat(%d)", int(f))
+}
+
+// BytesPerSample returns the size of one sample, or 0 for an unknown format.
+func (f SampleFormat) BytesPerSample() int {
+	switch f {
+	case SampleFormatU8:
+		return 1
+	case SampleFormatS16LE, SampleFormatS16BE:
+		return 2
+	case SampleFormatS24LE, SampleFormatS24BE:
+		return 3
+	case SampleFormatS32LE, SampleFormatS32BE, SampleFormatF32LE, SampleFormatF32BE:
+		return 4
+	case SampleFormatF64LE, SampleFormatF64BE:
+		return 8
+	default:
+		return 0
+	}
+}
+
+func (f SampleFormat) bigEndian() bool {
+	switch f {
+	case SampleFormatS16BE, SampleFormatS24BE, SampleFormatS32BE, SampleFormatF32BE, SampleFormatF64BE:
+		return true
+	default:
+		return false
+	}
+}
+
+// decode converts one sample, len(b) == f.BytesPerSample(), to a value in [-1, 1].
+func (f SampleFormat) decode(b []byte) float64 {
+	var order binary.ByteOrder = binary.LittleEndian
+	if f.bigEndian() {
+		order = binary.BigEndian
+	}
+	switch f {
+	case SampleFormatU8:
+		return (float64(b[0]) - 128) / 128
+	case SampleFormatS16LE, SampleFormatS16BE:
+		return float64(int16(order.Uint16(b))) / (1 << 15)
+	case SampleFormatS24LE:
+		return float64(int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24)>>8) / (1 << 23)
+	case SampleFormatS24BE:
+		return float64(int32(uint32(b[2])<<8|uint32(b[1])<<16|uint32(b[0])<<24)>>8) / (1 << 23)
+	case SampleFormatS32LE, SampleFormatS32BE:
+		return float64(int32(order.Uint32(b))) / (1 << 31)
+	case SampleFormatF32LE, SampleFormatF32BE:
+		return float64(math.Float32frombits(order.Uint32(b)))
+	default:
+		return math.Float64frombits(order.Uint64(b))
+	}
+}
+
+// PCMFormat describes headerless interleaved PCM.
+type PCMFormat struct {
+	SampleFormat SampleFormat
+	Channels     int
+	SampleRate   int
+}
+
+func (f PCMFormat) validate() error {
+	switch {
+	case f.SampleFormat.BytesPerSample() == 0:
+		return fmt.Errorf("%w: unknown sample format %v", ErrInvalidPCMFormat, f.SampleFormat)
+	case f.Channels <= 0:
+		return fmt.Errorf("%w: %d channels", ErrInvalidPCMFormat, f.Channels)
+	case f.SampleRate <= 0:
+		return fmt.Errorf("%w: sample rate %d", ErrInvalidPCMFormat, f.SampleRate)
+	}
+	return nil
+}
+
+// PCMDecoder decodes headerless PCM of a fixed format. Raw PCM cannot be sniffed, so
+// use it as the fallback of a DecoderSet, e.g. NewDecoderSet(&PCMDecoder{Format: f}).
+type PCMDecoder struct {
+	Format PCMFormat
+}
+
+func (d *PCMDecoder) Decode(_ context.Context, r io.Reader, opts DecodeOptions) ([]int16, error) {
+	return DecodePCM(r, d.Format, opts)
+}
+
+// DecodeStream converts the selected window of r while it is read.
+func (d *PCMDecoder) DecodeStream(_ context.Context, r io.Reader, opts DecodeOptions) (io.ReadCloser, error) {
+	return decodePCMStream(r, d.Format, opts)
+}
+
+// DecodePCM converts the selected window of headerless PCM read from r into
+// 16 kHz mono samples, suitable for SignatureGenerator.MakeSignatureFromBuffer.
+// Use bytes.NewReader to convert a capture buffer.
+func DecodePCM(r io.Reader, format PCMFormat, opts DecodeOptions) ([]int16, error) {
+	pcm, err := decodePCMStream(r, format, opts)
+	if err != nil {
+		return nil, err
+	}
+	return readDecoded(pcm)
+}
+
+func decodePCMStream(r io.Reader, format PCMFormat, opts DecodeOptions) (*pcmStream, error) {
+	if err := opts.checkSingleStream(); err != nil {
+		return nil, err
+	}
+	if err := format.validate(); err != nil {
+		return nil, err
+	}
+	return convertPCMWindow(r, format, math.MaxInt64, opts)
+}
+
+// convertPCMWindow returns a pcmStream converting the selected window of the interleaved
+// PCM read from r, reading no more than limit bytes.
+func convertPCMWindow(r io.Reader, format PCMFormat, limit int64, opts DecodeOptions) (*pcmStream, error) {
+	blocks, err := readPCMWindow(r, format, limit, opts)
+	if err != nil {
+		return nil, err
+	}
+	return newPCMStream(func() ([]float64, error) {
+		block, err := blocks.next()
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("failed to read PCM data: %w", err)
+		}
+		return block, err
+	}, format.SampleRate, format.Channels)
+}
+
+// readPCMWindow returns the reader of the selected window of interleaved PCM read
+// from r, reading no more than limit bytes. Audio before the offset is skipped with
+// Seek when r supports it.
+func readPCMWindow(r io.Reader, format PCMFormat, limit int64, opts DecodeOptions) (*pcmBlocks, error) {
+	blockAlign := int64(format.SampleFormat.BytesPerSample() * format.Channels)
+	startFrame, frameCount := opts.frames(format.SampleRate)
+	if skip := min(startFrame*blockAlign, limit); skip > 0 {
+		if err := skipBytes(r, skip); err != nil {
+			return nil, fmt.Errorf("failed to skip PCM data: %w", err)
+		}
+		limit -= skip
+	}
+	if frameCount > 0 {
+		limit = min(frameCount*blockAlign, limit)
+	}
+	return newPCMBlocks(io.LimitReader(r, limit), format), nil
+}
+
+// readPCM returns the interleaved samples read from r normalized to [-1, 1].
+func readPCM(r io.Reader, format PCMFormat) ([]float64, error) {
+	blocks := newPCMBlocks(r, format)
+	var samples []float64
+	for {
+		block, err := blocks.next()
+		if errors.Is(err, io.EOF) {
+			return samples, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read PCM data: %w", err)
+		}
+		samples = append(samples, block...)
+	}
+}
@@ -0,0 +1,218 @@
+package goshazam
+
+import "math"
+
+const (
+	limiterLookahead = sampleRate * 5 / 1000 // 5 ms
+	limiterRelease   = 0.05                  // seconds
+)
+
+// Stage is one step of a preprocessing chain. It receives 16 kHz mono samples
+// normalized to [-1, 1] and returns the processed samples, which may share
+// the input's backing array. Stages may push samples out of range; they are
+// clipped when converted back to 16-bit.
+type Stage func(samples []float64) []float64
+
+// Chain composes stages into one that runs them in order.
+func Chain(stages ...Stage) Stage {
+	return func(samples []float64) []float64 {
+		for _, stage := range stages {
+			samples = stage(samples)
+		}
+		return samples
+	}
+}
+
+// Preprocess runs 16 kHz mono samples through the stages and returns the result.
+// The input is not modified.
+func Preprocess(samples []int16, stages ...Stage) []int16 {
+	if len(stages) == 0 {
+		return samples
+	}
+	return floatToInt16(Chain(stages...)(int16ToFloat(samples)))
+}
+
+// PeakCounts is the number of spectral peaks a signature has in each frequency band.
+type PeakCounts map[FrequencyBand]int
+
+// Total returns the number of peaks over all bands.
+func (p PeakCounts) Total() int {
+	total := 0
+	for _, n := range p {
+		total += n
+	}
+	return total
+}
+
+// PeakCounts counts the peaks of the signature per frequency band.
+func (d *DecodedSignature) PeakCounts() PeakCounts {
+	counts := make(PeakCounts, len(d.FrequencyBandToSoundPeaks))
+	for band, peaks := range d.FrequencyBandToSoundPeaks {
+		counts[band] = len(peaks)
+	}
+	return counts
+}
+
+// MeasurePreprocessing fingerprints 16 kHz mono samples as is and after the stages,
+// and returns the peak counts of both signatures. Comparing them on real recordings
+// shows whether a chain uncovers peaks that noise was burying, or only removes some.
+func MeasurePreprocessing(samples []int16, stages ...Stage) (before, after PeakCounts) {
+	original := NewSignatureGenerator().MakeSignatureFromBuffer(samples)
+	processed := NewSignatureGenerator().MakeSignatureFromBuffer(Preprocess(samples, stages...))
+	return original.PeakCounts(), processed.PeakCounts()
+}
+
+// RemoveDC subtracts the mean of the samples, removing a constant offset.
+func RemoveDC() Stage {
+	return func(samples []float64) []float64 {
+		if len(samples) == 0 {
+			return samples
+		}
+		var sum float64
+		for _, v := range samples {
+			sum += v
+		}
+		mean := sum / float64(len(samples))
+		for i := range samples {
+			samples[i] -= mean
+		}
+		return samples
+	}
+}
+
+// NormalizePeak scales the samples so their highest absolute value is at targetDBFS.
+func NormalizePeak(targetDBFS float64) Stage {
+	return func(samples []float64) []float64 {
+		var peak float64
+		for _, v := range samples {
+			peak = math.Max(peak, math.Abs(v))
+		}
+		return applyGain(samples, peak, targetDBFS)
+	}
+}
+
+// NormalizeRMS scales the samples so their RMS level is at targetDBFS. Peaks may
+// end up above full scale, so it is usually followed by Limiter.
+func NormalizeRMS(targetDBFS float64) Stage {
+	return func(samples []float64) []float64 {
+		if len(samples) == 0 {
+			return samples
+		}
+		var energy float64
+		for _, v := range samples {
+			energy += v * v
+		}
+		return applyGain(samples, math.Sqrt(energy/float64(len(samples))), targetDBFS)
+	}
+}
+
+// applyGain scales samples so that level becomes targetDBFS; silence is left alone.
+func applyGain(samples []float64, level, targetDBFS float64) []float64 {
+	if level == 0 {
+		return samples
+	}
+	gain := dbToAmplitude(targetDBFS) / level
+	for i := range samples {
+		samples[i] *= gain
+	}
+	return samples
+}
+
+// HighPass is a fourth-order Butterworth high-pass filter that removes rumble and
+// mains hum below cutoffHz, e.g. 100 for 50/60 Hz hum.
+func HighPass(cutoffHz float64) Stage {
+	// Two biquad sections whose Q values give a Butterworth response when cascaded.
+	sections := []biquad{
+		newHighPassBiquad(cutoffHz, 1/(2*math.Cos(math.Pi/8))),
+		newHighPassBiquad(cutoffHz, 1/(2*math.Cos(3*math.Pi/8))),
+	}
+	return func(samples []float64) []float64 {
+		for _, section := range sections {
+			section.filter(samples)
+		}
+		return samples
+	}
+}
+
+// biquad holds normalized second-order filter coefficients.
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+}
+
+// newHighPassBiquad returns the high-pass section from the Audio EQ Cookbook.
+func newHighPassBiquad(cutoffHz, q float64) biquad {
+	w0 := 2 * math.Pi * cutoffHz / sampleRate
+	alpha := math.Sin(w0) / (2 * q)
+	cosW0 := math.Cos(w0)
+	a0 := 1 + alpha
+	return biquad{
+		b0: (1 + cosW0) / 2 / a0,
+		b1: -(1 + cosW0) / a0,
+		b2: (1 + cosW0) / 2 / a0,
+		a1: -2 * cosW0 / a0,
+		a2: (1 - alpha) / a0,
+	}
+}
+
+// filter runs the section over samples in place, starting from rest.
+func (f biquad) filter(samples []float64) {
+	var x1, x2, y1, y2 float64
+	for i, x := range samples {
+		y := f.b0*x + f.b1*x1 + f.b2*x2 - f.a1*y1 - f.a2*y2
+		x2, x1 = x1, x
+		y2, y1 = y1, y
+		samples[i] = y
+	}
+}
+
+// Limiter keeps the samples below ceilingDBFS. Gain ramps down linearly over a short
+// look-ahead before a peak and recovers smoothly afterwards, which distorts far less than clipping.
+func Limiter(ceilingDBFS float64) Stage {
+	ceiling := dbToAmplitude(ceilingDBFS)
+	return func(samples []float64) []float64 {
+		for i, g := range limiterGain(samples, ceiling) {
+			samples[i] = math.Max(-ceiling, math.Min(ceiling, samples[i]*g))
+		}
+		return samples
+	}
+}
+
+// limiterGain returns the gain Limiter applies to each sample. Every sample above the
+// ceiling needs a gain that brings it down to it; the gain falls towards that linearly over
+// the limiterLookahead samples before it, so it drops by at most 1/limiterLookahead per
+// sample, and rises back towards 1 with the release time constant.
+func limiterGain(samples []float64, ceiling float64) []float64 {
+	release := 1 - math.Exp(-1/(limiterRelease*sampleRate))
+
+	// envelope[i] is the highest gain that still lets every later sample reach its gain in time.
+	envelope := make([]float64, len(samples))
+	for i := range envelope {
+		envelope[i] = 1
+	}
+	for j, v := range samples {
+		a := math.Abs(v)
+		if a <= ceiling {
+			continue
+		}
+		needed := ceiling / a
+		for i := max(j-limiterLookahead, 0); i <= j; i++ {
+			ramp := needed + (1-needed)*float64(j-i)/limiterLookahead
+			envelope[i] = math.Min(envelope[i], ramp)
+		}
+	}
+
+	gain := 1.0
+	for i, e := range envelope {
+		if e < gain {
+			gain = e
+		} else {
+			gain += (e - gain) * release
+		}
+		envelope[i] = gain
+	}
+	return envelope
+}
+
+func dbToAmplitude(dB float64) float64 {
+	return math.Pow(10, dB/20)
+}
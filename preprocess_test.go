@@ -0,0 +1,63 @@
+package goshazam
+
+import (
+	"math"
+	"testing"
+)
+
+// limiterFixture returns a 440 Hz tone at half scale with bursts far above full scale,
+// one of them starting abruptly.
+func limiterFixture() []float64 {
+	samples := make([]float64, sampleRate)
+	for i := range samples {
+		amplitude := 0.5
+		switch {
+		case i >= 4000 && i < 4400:
+			amplitude = 3
+		case i >= 9000 && i < 12000:
+			amplitude = 1.5 + float64(i-9000)/3000
+		}
+		samples[i] = amplitude * math.Sin(2*math.Pi*440*float64(i)/sampleRate)
+	}
+	// A single sample spike.
+	samples[14000] = 4
+	return samples
+}
+
+func TestLimiter(t *testing.T) {
+	const ceilingDBFS = -1
+	ceiling := dbToAmplitude(ceilingDBFS)
+	input := limiterFixture()
+
+	gain := limiterGain(input, ceiling)
+	for i, v := range input {
+		if math.Abs(v*gain[i]) > ceiling+1e-12 {
+			t.Fatalf("sample %d is %v after the gain, above the ceiling %v", i, v*gain[i], ceiling)
+		}
+	}
+	previous := 1.0
+	for i, g := range gain {
+		if step := math.Abs(g - previous); step > 1.0/limiterLookahead+1e-12 {
+			t.Fatalf("gain changes by %v at sample %d, want at most %v", step, i, 1.0/limiterLookahead)
+		}
+		previous = g
+	}
+	// The gain starts to fall a look-ahead before the spike and not earlier.
+	if gain[14000-limiterLookahead-1] < gain[14000-limiterLookahead-2] {
+		t.Errorf("gain falls more than %d samples before the spike", limiterLookahead)
+	}
+	if gain[14000-limiterLookahead/2] >= gain[14000-limiterLookahead] {
+		t.Errorf("gain does not fall within %d samples before the spike", limiterLookahead)
+	}
+
+	output := Limiter(ceilingDBFS)(append([]float64(nil), input...))
+	for i, v := range output {
+		if math.Abs(v) > ceiling {
+			t.Fatalf("Limiter output %d is %v, above the ceiling %v", i, v, ceiling)
+		}
+	}
+	// Far from the bursts the tone passes unchanged.
+	if math.Abs(output[2000]-input[2000]) > 1e-12 {
+		t.Errorf("Limiter changed sample 2000 from %v to %v", input[2000], output[2000])
+	}
+}
@@ -0,0 +1,117 @@
+package goshazam
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// ErrProbeUnsupported is returned by DecoderSet.Probe when neither the matched decoder nor the fallback is a Prober.
+var ErrProbeUnsupported = errors.New("probing not supported for this format")
+
+// MediaInfo describes a media file without decoding its audio.
+type MediaInfo struct {
+	// Duration is zero when the file does not declare its length.
+	Duration time.Duration
+	// Container and Codec use ffprobe's names, e.g. "ogg" and "vorbis".
+	Container  string
+	Codec      string
+	SampleRate int
+	Channels   int
+	// Bitrate is in bits per second: the nominal rate when the file declares one, the average otherwise.
+	Bitrate int
+	// Tags holds the embedded metadata with lowercased keys such as "title", "artist" and "album".
+	Tags map[string]string
+}
+
+// Prober is implemented by decoders that can describe a file without decoding it.
+type Prober interface {
+	Probe(ctx context.Context, path string) (*MediaInfo, error)
+}
+
+// Probe describes the file at path. It uses the prober of the sniffed format and
+// falls back to the set's fallback decoder, which for the default set is ffprobe.
+func (s *DecoderSet) Probe(ctx context.Context, path string) (*MediaInfo, error) {
+	f, err := s.lookupFile(path)
+	if err != nil && !errors.Is(err, ErrUnknownFormat) {
+		return nil, err
+	}
+	if prober, ok := f.Decoder.(Prober); ok {
+		return prober.Probe(ctx, path)
+	}
+	s.mu.RLock()
+	fallback := s.fallback
+	s.mu.RUnlock()
+	if prober, ok := fallback.(Prober); ok {
+		return prober.Probe(ctx, path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("%w: %s", ErrProbeUnsupported, f.Name)
+}
+
+// Probe describes the file at path using the default decoder set.
+func Probe(ctx context.Context, path string) (*MediaInfo, error) {
+	return DefaultDecoderSet().Probe(ctx, path)
+}
+
+// probeFile opens path and hands it to probe along with its size in bytes.
+func probeFile(path string, probe func(r io.ReadSeeker, size int64) (*MediaInfo, error)) (*MediaInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return probe(f, stat.Size())
+}
+
+// framesDuration converts a frame count at the given sample rate to a duration.
+func framesDuration(frames int64, rate int) time.Duration {
+	if rate <= 0 {
+		return 0
+	}
+	return time.Duration(frames) * time.Second / time.Duration(rate)
+}
+
+// averageBitrate returns the bitrate of size bytes of audio lasting d.
+func averageBitrate(size int64, d time.Duration) int {
+	if d <= 0 {
+		return 0
+	}
+	return int(float64(size*8) / d.Seconds())
+}
+
+// parseVorbisComments turns "KEY=value" comments, as used by Vorbis and FLAC, into tags.
+// Repeated keys are joined with "; ".
+func parseVorbisComments(comments []string) map[string]string {
+	tags := make(map[string]string)
+	for _, comment := range comments {
+		key, value, ok := strings.Cut(comment, "=")
+		if !ok || key == "" {
+			continue
+		}
+		addTag(tags, key, value)
+	}
+	return tags
+}
+
+func addTag(tags map[string]string, key, value string) {
+	key = strings.ToLower(key)
+	if value == "" {
+		return
+	}
+	if prev, ok := tags[key]; ok {
+		value = prev + "; " + value
+	}
+	tags[key] = value
+}
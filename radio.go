@@ -0,0 +1,273 @@
+package goshazam
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultRadioInterval is how often RecognizeRadio fingerprints the stream.
+const DefaultRadioInterval = 30 * time.Second
+
+// RadioStation is what an ICY/Shoutcast station announces about itself in its response headers.
+type RadioStation struct {
+	Name        string
+	Genre       string
+	URL         string
+	Description string
+	// Bitrate is the announced bitrate in kbit/s, or 0 when unknown.
+	Bitrate     int
+	ContentType string
+}
+
+// RadioMatch is the recognition outcome for one window of a radio stream.
+type RadioMatch struct {
+	// Offset is where the window starts, measured in decoded audio from the start of the stream.
+	Offset  time.Duration
+	Station RadioStation
+	// StreamTitle is the station's own title metadata when the window was captured,
+	// often "Artist - Title". It may lag the audio by the decoder's buffering.
+	StreamTitle string
+	// Metadata holds every field of the station's latest metadata block, e.g. StreamUrl.
+	Metadata map[string]string
+	Result   *RecognizeResult
+	Err      error
+}
+
+// icyReader strips the metadata blocks that ICY servers interleave with the
+// audio every metaInt bytes and keeps the latest of them.
+type icyReader struct {
+	r        io.Reader
+	metaInt  int
+	left     int // audio bytes until the next metadata block
+	mu       sync.Mutex
+	metadata map[string]string
+}
+
+func newICYReader(r io.Reader, metaInt int) *icyReader {
+	return &icyReader{r: r, metaInt: metaInt, left: metaInt}
+}
+
+func (m *icyReader) Read(p []byte) (int, error) {
+	if m.metaInt <= 0 {
+		return m.r.Read(p)
+	}
+	if m.left == 0 {
+		if err := m.readMetadata(); err != nil {
+			return 0, err
+		}
+		m.left = m.metaInt
+	}
+	n, err := m.r.Read(p[:min(len(p), m.left)])
+	m.left -= n
+	return n, err
+}
+
+// readMetadata reads one block: a length byte counting 16-byte units, then the padded text.
+// An empty block means the metadata did not change.
+func (m *icyReader) readMetadata() error {
+	var length [1]byte
+	if _, err := io.ReadFull(m.r, length[:]); err != nil {
+		return err
+	}
+	if length[0] == 0 {
+		return nil
+	}
+	block := make([]byte, int(length[0])*16)
+	if _, err := io.ReadFull(m.r, block); err != nil {
+		return noEOF(err)
+	}
+	metadata := parseICYMetadata(string(bytes.TrimRight(block, "\x00")))
+	m.mu.Lock()
+	m.metadata = metadata
+	m.mu.Unlock()
+	return nil
+}
+
+// latest returns the most recent metadata, which callers must not modify.
+func (m *icyReader) latest() map[string]string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.metadata
+}
+
+// parseICYMetadata splits "StreamTitle='Artist - Title';StreamUrl='...';" into its fields.
+// Values are not escaped, so a value only ends at a quote followed by a semicolon.
+func parseICYMetadata(text string) map[string]string {
+	fields := make(map[string]string)
+	for text != "" {
+		key, rest, ok := strings.Cut(text, "='")
+		if !ok {
+			break
+		}
+		value, next, ok := strings.Cut(rest, "';")
+		if !ok {
+			value = strings.TrimSuffix(rest, "'")
+		}
+		fields[strings.TrimSpace(key)] = value
+		text = next
+	}
+	return fields
+}
+
+func noEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// radioClient returns a copy of the client without its timeout, since a stream never
+// ends; ctx stops it instead. For plain HTTP the copy's connections present the
+// "ICY 200 OK" status line of Shoutcast v1 servers as HTTP/1.0, which net/http can
+// parse, unless the client has a custom transport. release frees the copy's connections.
+func (c *ShazamClient) radioClient(rawURL string) (client *http.Client, release func()) {
+	copied := *c.client
+	copied.Timeout = 0
+	roundTripper := copied.Transport
+	if roundTripper == nil {
+		roundTripper = http.DefaultTransport
+	}
+	transport, ok := roundTripper.(*http.Transport)
+	if u, err := url.Parse(rawURL); !ok || err != nil || u.Scheme != "http" {
+		return &copied, func() {}
+	}
+	transport = transport.Clone()
+	dial := transport.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &icyConn{Conn: conn}, nil
+	}
+	copied.Transport = transport
+	return &copied, transport.CloseIdleConnections
+}
+
+// icyConn rewrites a leading "ICY " status line to "HTTP/1.0 ".
+type icyConn struct {
+	net.Conn
+	checked bool
+	pending []byte
+}
+
+func (c *icyConn) Read(p []byte) (int, error) {
+	if !c.checked {
+		c.checked = true
+		head := make([]byte, 4)
+		n, err := io.ReadFull(c.Conn, head)
+		if n == 0 {
+			return 0, err
+		}
+		c.pending = head[:n]
+		if string(c.pending) == "ICY " {
+			c.pending = []byte("HTTP/1.0 ")
+		}
+	}
+	if len(c.pending) > 0 {
+		n := copy(p, c.pending)
+		c.pending = c.pending[n:]
+		return n, nil
+	}
+	return c.Conn.Read(p)
+}
+
+// openRadio connects to an ICY/Shoutcast or plain HTTP audio stream and asks for interleaved metadata.
+func (c *ShazamClient) openRadio(ctx context.Context, rawURL string) (*http.Response, RadioStation, func(), error) {
+	client, release := c.radioClient(rawURL)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		release()
+		return nil, RadioStation{}, nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("User-Agent", c.getRandomUserAgent())
+	req.Header.Set("Icy-MetaData", "1")
+	resp, err := client.Do(req)
+	if err != nil {
+		release()
+		return nil, RadioStation{}, nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		release()
+		return nil, RadioStation{}, nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	bitrate, _ := strconv.Atoi(strings.TrimSpace(strings.Split(resp.Header.Get("Icy-Br"), ",")[0]))
+	station := RadioStation{
+		Name:        resp.Header.Get("Icy-Name"),
+		Genre:       resp.Header.Get("Icy-Genre"),
+		URL:         resp.Header.Get("Icy-Url"),
+		Description: resp.Header.Get("Icy-Description"),
+		Bitrate:     bitrate,
+		ContentType: resp.Header.Get("Content-Type"),
+	}
+	return resp, station, release, nil
+}
+
+// radioFileName returns a file name whose extension helps pick a decoder when the
+// stream is joined in the middle of a frame and cannot be sniffed.
+func radioFileName(rawURL, contentType string) string {
+	switch strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0])) {
+	case "audio/mpeg", "audio/mp3", "audio/mpeg3":
+		return "stream.mp3"
+	}
+	if u, err := url.Parse(rawURL); err == nil {
+		return path.Base(u.Path)
+	}
+	return ""
+}
+
+// RecognizeRadio connects to an internet radio stream at rawURL, decodes it
+// continuously and fingerprints a rolling window of it every WithInterval, calling
+// handle with each outcome next to the station's own metadata. Windows that fail,
+// e.g. with ErrTooQuiet during a silent break, are reported through RadioMatch.Err.
+//
+// It runs until ctx is done, handle returns an error, or the station ends the stream,
+// and returns ctx.Err(), handle's error, or nil respectively. Decoding pauses while a
+// window is recognized and handled, so both should take less than the interval.
+func (c *ShazamClient) RecognizeRadio(ctx context.Context, rawURL string, handle func(RadioMatch) error, opts ...RecognizeOption) error {
+	o, err := newRecognizeOptions(opts)
+	if err != nil {
+		return err
+	}
+	resp, station, release, err := c.openRadio(ctx, rawURL)
+	if err != nil {
+		return fmt.Errorf("error connecting to stream: %w", err)
+	}
+	defer release()
+	defer resp.Body.Close()
+
+	metaInt, _ := strconv.Atoi(resp.Header.Get("Icy-Metaint"))
+	audio := newICYReader(resp.Body, metaInt)
+	pcm, err := c.decoders.DecodeLive(ctx, audio, radioFileName(rawURL, station.ContentType))
+	if err != nil {
+		return fmt.Errorf("error decoding stream: %w", err)
+	}
+	defer pcm.Close()
+
+	return c.recognizeWindows(ctx, pcm, o, func(start time.Duration, result *RecognizeResult, err error) error {
+		metadata := audio.latest()
+		return handle(RadioMatch{
+			Offset:      start,
+			Station:     station,
+			StreamTitle: metadata["StreamTitle"],
+			Metadata:    metadata,
+			Result:      result,
+			Err:         err,
+		})
+	})
+}
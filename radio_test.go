@@ -0,0 +1,231 @@
+package goshazam
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestParseICYMetadata(t *testing.T) {
+	for _, tt := range []struct {
+		text string
+		want map[string]string
+	}{
+		{
+			"StreamTitle='Artist - Title';StreamUrl='http://example.com/now';",
+			map[string]string{"StreamTitle": "Artist - Title", "StreamUrl": "http://example.com/now"},
+		},
+		{
+			// Quotes inside a value do not end it unless a semicolon follows.
+			"StreamTitle='Guns N' Roses - Sweet Child O' Mine';",
+			map[string]string{"StreamTitle": "Guns N' Roses - Sweet Child O' Mine"},
+		},
+		{
+			"StreamTitle='It's a 'quoted' title'",
+			map[string]string{"StreamTitle": "It's a 'quoted' title"},
+		},
+		{
+			"StreamTitle='';StreamUrl='';",
+			map[string]string{"StreamTitle": "", "StreamUrl": ""},
+		},
+		{
+			" StreamTitle='Spaced';",
+			map[string]string{"StreamTitle": "Spaced"},
+		},
+		{"no fields", map[string]string{}},
+		{"", map[string]string{}},
+	} {
+		if got := parseICYMetadata(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseICYMetadata(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+// icyBlock returns a metadata block holding text: a length byte counting 16-byte units,
+// then text padded with zeros.
+func icyBlock(text string) []byte {
+	units := (len(text) + 15) / 16
+	block := make([]byte, 1+16*units)
+	block[0] = byte(units)
+	copy(block[1:], text)
+	return block
+}
+
+func TestICYReader(t *testing.T) {
+	const metaInt = 16
+	audio := bytes.Repeat([]byte("0123456789abcdef"), 4)
+	var stream []byte
+	stream = append(stream, audio[:16]...)
+	stream = append(stream, icyBlock("StreamTitle='First - Song';")...)
+	stream = append(stream, audio[16:32]...)
+	// An empty block keeps the metadata as it was.
+	stream = append(stream, 0)
+	stream = append(stream, audio[32:48]...)
+	stream = append(stream, icyBlock("StreamTitle='Second - Song';StreamUrl='http://example.com';")...)
+	stream = append(stream, audio[48:64]...)
+
+	for name, wrap := range map[string]func(io.Reader) io.Reader{
+		"whole reads":    func(r io.Reader) io.Reader { return r },
+		"one byte reads": iotest.OneByteReader,
+		"half reads":     iotest.HalfReader,
+	} {
+		t.Run(name, func(t *testing.T) {
+			r := newICYReader(wrap(bytes.NewReader(stream)), metaInt)
+			var got []byte
+			buf := make([]byte, 24)
+			var titles []string
+			for {
+				n, err := r.Read(buf)
+				got = append(got, buf[:n]...)
+				if title := r.latest()["StreamTitle"]; len(titles) == 0 || titles[len(titles)-1] != title {
+					titles = append(titles, title)
+				}
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if !bytes.Equal(got, audio) {
+				t.Errorf("got audio %q, want %q", got, audio)
+			}
+			if want := []string{"", "First - Song", "Second - Song"}; !reflect.DeepEqual(titles, want) {
+				t.Errorf("got titles %q, want %q", titles, want)
+			}
+			if url := r.latest()["StreamUrl"]; url != "http://example.com" {
+				t.Errorf("got StreamUrl %q, want the latest block's", url)
+			}
+		})
+	}
+}
+
+func TestICYReaderTruncatedBlock(t *testing.T) {
+	stream := append(bytes.Repeat([]byte{1}, 16), icyBlock("StreamTitle='Cut - Off';")[:10]...)
+	r := newICYReader(bytes.NewReader(stream), 16)
+	got, err := io.ReadAll(r)
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("got error %v, want io.ErrUnexpectedEOF", err)
+	}
+	if len(got) != 16 {
+		t.Errorf("got %d audio bytes, want the 16 before the block", len(got))
+	}
+	if r.latest() != nil {
+		t.Errorf("got metadata %q from a truncated block", r.latest())
+	}
+}
+
+func TestICYReaderWithoutMetadata(t *testing.T) {
+	audio := bytes.Repeat([]byte{0, 'I', 'C', 'Y'}, 100)
+	got, err := io.ReadAll(newICYReader(bytes.NewReader(audio), 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, audio) {
+		t.Error("audio changed without a metadata interval")
+	}
+}
+
+func TestICYConn(t *testing.T) {
+	for _, tt := range []struct {
+		sent, want string
+	}{
+		{"ICY 200 OK\r\nicy-name: Radio\r\n\r\n", "HTTP/1.0 200 OK\r\nicy-name: Radio\r\n\r\n"},
+		{"HTTP/1.1 200 OK\r\n\r\nICY ", "HTTP/1.1 200 OK\r\n\r\nICY "},
+		{"IC", "IC"},
+		{"", ""},
+	} {
+		server, client := net.Pipe()
+		go func() {
+			// Write byte by byte, so the status line arrives in pieces.
+			for i := range len(tt.sent) {
+				server.Write([]byte{tt.sent[i]})
+			}
+			server.Close()
+		}()
+		got, err := io.ReadAll(iotest.OneByteReader(&icyConn{Conn: client}))
+		client.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("read %q from %q, want %q", got, tt.sent, tt.want)
+		}
+	}
+}
+
+// serveICY accepts connections on a new listener and answers each with a Shoutcast v1
+// response, whose status line starts with "ICY" instead of an HTTP version.
+func serveICY(t *testing.T, body string) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if _, err := http.ReadRequest(bufio.NewReader(conn)); err != nil {
+					return
+				}
+				io.WriteString(conn, "ICY 200 OK\r\nicy-name: Test Radio\r\nicy-br: 128\r\nicy-metaint: 16\r\n"+
+					"content-type: audio/mpeg\r\n\r\n"+body)
+			}()
+		}
+	}()
+	return "http://" + ln.Addr().String() + "/stream"
+}
+
+// wrappingTransport is a custom RoundTripper around the default transport.
+type wrappingTransport struct{}
+
+func (wrappingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestOpenRadioICYStatusLine(t *testing.T) {
+	url := serveICY(t, "audio")
+
+	t.Run("default transport", func(t *testing.T) {
+		resp, station, release, err := NewShazamClient().openRadio(context.Background(), url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer release()
+		defer resp.Body.Close()
+		want := RadioStation{Name: "Test Radio", Bitrate: 128, ContentType: "audio/mpeg"}
+		if station != want {
+			t.Errorf("got station %+v, want %+v", station, want)
+		}
+		if resp.Header.Get("Icy-Metaint") != "16" {
+			t.Errorf("got metadata interval %q, want 16", resp.Header.Get("Icy-Metaint"))
+		}
+		body, _ := io.ReadAll(resp.Body)
+		if string(body) != "audio" {
+			t.Errorf("got body %q, want %q", body, "audio")
+		}
+	})
+
+	t.Run("custom transport", func(t *testing.T) {
+		// The status line is only rewritten on connections the client's own transport dials.
+		c := NewShazamClient(WithHTTPClient(&http.Client{Transport: wrappingTransport{}}))
+		_, _, _, err := c.openRadio(context.Background(), url)
+		if err == nil || !strings.Contains(err.Error(), "malformed HTTP") {
+			t.Errorf("got error %v, want the ICY status line rejected", err)
+		}
+	})
+}
@@ -0,0 +1,373 @@
+package goshazam
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+const (
+	// The filter parameters mirror libswresample's defaults (filter_size=32,
+	// phase_shift=10, cutoff=0.97, Kaiser beta=9) so that converted audio
+	// fingerprints the same as ffmpeg's "-ar 16000 -ac 1" output.
+	resampleFilterSize  = 32
+	resampleMaxPhases   = 1 << 10
+	resampleCutoff      = 0.97
+	resampleKaiserBeta  = 9.0
+	besselI0Convergence = 1e-21
+	// pcmBlockFrames is how many frames pcmBlocks reads at a time.
+	pcmBlockFrames = 4096
+)
+
+// Resampler converts mono audio between two sample rates with a polyphase
+// Kaiser-windowed sinc filter. The filter also acts as the anti-aliasing
+// low-pass when downsampling. A Resampler is safe for concurrent use.
+type Resampler struct {
+	fromRate  int
+	toRate    int
+	upFactor  int
+	downStep  int
+	halfTaps  int
+	numPhases int
+	filters   [][]float64
+}
+
+// NewResampler builds a resampler from fromRate to toRate Hz.
+func NewResampler(fromRate, toRate int) (*Resampler, error) {
+	if fromRate <= 0 || toRate <= 0 {
+		return nil, fmt.Errorf("invalid resampling rates %d -> %d", fromRate, toRate)
+	}
+	g := gcd(fromRate, toRate)
+	r := &Resampler{
+		fromRate: fromRate,
+		toRate:   toRate,
+		upFactor: toRate / g,
+		downStep: fromRate / g,
+	}
+
+	factor := math.Min(1, float64(toRate)/float64(fromRate))
+	r.halfTaps = int(math.Ceil(resampleFilterSize / 2 / factor))
+	r.numPhases = r.upFactor
+	if r.numPhases > resampleMaxPhases {
+		r.numPhases = resampleMaxPhases
+	}
+
+	cutoff := resampleCutoff * factor / 2
+	norm := besselI0(resampleKaiserBeta)
+	r.filters = make([][]float64, r.numPhases)
+	for p := range r.filters {
+		taps := make([]float64, 2*r.halfTaps)
+		frac := float64(p) / float64(r.numPhases)
+		var sum float64
+		for k := range taps {
+			x := float64(k-r.halfTaps+1) - frac
+			w := x / float64(r.halfTaps)
+			if w <= -1 || w >= 1 {
+				continue
+			}
+			window := besselI0(resampleKaiserBeta*math.Sqrt(1-w*w)) / norm
+			taps[k] = 2 * cutoff * sinc(2*cutoff*x) * window
+			sum += taps[k]
+		}
+		for k := range taps {
+			taps[k] /= sum
+		}
+		r.filters[p] = taps
+	}
+	return r, nil
+}
+
+// Resample converts samples from the resampler's input rate to its output rate.
+// Samples outside the buffer are treated as silence.
+func (r *Resampler) Resample(samples []float64) []float64 {
+	if r.fromRate == r.toRate || len(samples) == 0 {
+		return samples
+	}
+	outLen := int(int64(len(samples)) * int64(r.toRate) / int64(r.fromRate))
+	out := make([]float64, outLen)
+	for n := range out {
+		start, phase := r.position(int64(n))
+		out[n] = r.filter(samples, int(start), phase)
+	}
+	return out
+}
+
+// position returns the index of the first input sample that output sample n
+// depends on, and the filter phase to weigh the inputs with.
+func (r *Resampler) position(n int64) (start int64, phase int) {
+	pos := n * int64(r.downStep)
+	base := pos / int64(r.upFactor)
+	phase = int((pos%int64(r.upFactor)*int64(r.numPhases) + int64(r.upFactor)/2) / int64(r.upFactor))
+	if phase == r.numPhases {
+		base, phase = base+1, 0
+	}
+	return base - int64(r.halfTaps) + 1, phase
+}
+
+// filter computes one output sample from the inputs starting at samples[start].
+func (r *Resampler) filter(samples []float64, start, phase int) float64 {
+	var acc float64
+	for k, h := range r.filters[phase] {
+		idx := start + k
+		if idx >= 0 && idx < len(samples) {
+			acc += h * samples[idx]
+		}
+	}
+	return acc
+}
+
+// resampleStream resamples unbounded input that arrives in blocks. Its output is
+// the same as Resample over the concatenated input once flush has emitted the end,
+// which stays buffered until then because it depends on input yet to come.
+type resampleStream struct {
+	r        *Resampler
+	pending  []float64 // input not needed by earlier outputs; pending[0] is input sample offset
+	offset   int64
+	next     int64 // index of the next output sample
+	received int64 // input samples written so far
+}
+
+// write appends the outputs that the input up to block allows to dst.
+func (s *resampleStream) write(dst, block []float64) []float64 {
+	if s.r.fromRate == s.r.toRate {
+		return append(dst, block...)
+	}
+	s.received += int64(len(block))
+	s.pending = append(s.pending, block...)
+	for {
+		start, phase := s.r.position(s.next)
+		if start+int64(2*s.r.halfTaps) > s.offset+int64(len(s.pending)) {
+			break
+		}
+		dst = append(dst, s.r.filter(s.pending, int(start-s.offset), phase))
+		s.next++
+	}
+	if start, _ := s.r.position(s.next); start > s.offset {
+		drop := min(start-s.offset, int64(len(s.pending)))
+		s.pending = s.pending[:copy(s.pending, s.pending[drop:])]
+		s.offset += drop
+	}
+	return dst
+}
+
+// flush appends the outputs that Resample computes past the last whole filter length,
+// treating the input after the end as silence, to dst.
+func (s *resampleStream) flush(dst []float64) []float64 {
+	end := s.received * int64(s.r.toRate) / int64(s.r.fromRate)
+	for ; s.next < end; s.next++ {
+		start, phase := s.r.position(s.next)
+		dst = append(dst, s.r.filter(s.pending, int(start-s.offset), phase))
+	}
+	return dst
+}
+
+// Resample converts mono samples from fromRate to toRate Hz.
+func Resample(samples []float64, fromRate, toRate int) ([]float64, error) {
+	if fromRate == toRate {
+		return samples, nil
+	}
+	r, err := NewResampler(fromRate, toRate)
+	if err != nil {
+		return nil, err
+	}
+	return r.Resample(samples), nil
+}
+
+// Downmix averages interleaved channels into a single channel. Every channel has the
+// same weight, since the channel count does not say which one is which: unlike ffmpeg's
+// "-ac 1", which leaves out the LFE channel and mixes the centre and surrounds at -3 dB,
+// Downmix includes the LFE channel of 5.1 audio. Its content lies below 120 Hz, under
+// the lowest band the signature keeps, so it only scales the other channels down.
+func Downmix(samples []float64, channels int) []float64 {
+	if channels <= 1 {
+		return samples
+	}
+	return downmixInto(make([]float64, 0, len(samples)/channels), samples, channels)
+}
+
+// downmixInto is Downmix appending to dst.
+func downmixInto(dst, samples []float64, channels int) []float64 {
+	if channels <= 1 {
+		return append(dst, samples...)
+	}
+	for i := 0; i < len(samples)/channels; i++ {
+		var sum float64
+		for c := 0; c < channels; c++ {
+			sum += samples[i*channels+c]
+		}
+		dst = append(dst, sum/float64(channels))
+	}
+	return dst
+}
+
+// ConvertPCM turns interleaved samples normalized to [-1, 1] at any rate and
+// channel count into the 16 kHz mono input of SignatureGenerator.MakeSignatureFromBuffer.
+func ConvertPCM(samples []float64, rate, channels int) ([]int16, error) {
+	if channels <= 0 {
+		return nil, fmt.Errorf("invalid channel count %d", channels)
+	}
+	mono, err := Resample(Downmix(samples, channels), rate, sampleRate)
+	if err != nil {
+		return nil, err
+	}
+	return floatToInt16(mono), nil
+}
+
+// pcmStream converts interleaved audio that a decoder produces a block at a time into
+// signed 16-bit little-endian 16 kHz mono PCM, the output of LiveDecoder and StreamDecoder.
+// Its output is the same as ConvertPCM over all of the blocks, but it holds no more than
+// a block and the resampler's filter in memory however long the input is.
+type pcmStream struct {
+	// next returns the next block, which may be reused by the following call, and io.EOF after the last.
+	next      func() ([]float64, error)
+	channels  int
+	stream    resampleStream
+	mono      []float64
+	resampled []float64
+	out       []byte // converted output; out[pos:] has not been read yet
+	pos       int
+	err       error
+}
+
+func newPCMStream(next func() ([]float64, error), rate, channels int) (*pcmStream, error) {
+	if channels <= 0 {
+		return nil, fmt.Errorf("invalid channel count %d", channels)
+	}
+	resampler, err := NewResampler(rate, sampleRate)
+	if err != nil {
+		return nil, err
+	}
+	return &pcmStream{
+		next:     next,
+		channels: channels,
+		stream:   resampleStream{r: resampler},
+	}, nil
+}
+
+func (s *pcmStream) Read(p []byte) (int, error) {
+	for s.pos == len(s.out) {
+		if s.err != nil {
+			return 0, s.err
+		}
+		block, err := s.next()
+		s.resampled = s.resampled[:0]
+		if err != nil {
+			s.err = err
+			if errors.Is(err, io.EOF) {
+				s.resampled = s.stream.flush(s.resampled)
+			}
+		} else {
+			s.mono = downmixInto(s.mono[:0], block, s.channels)
+			s.resampled = s.stream.write(s.resampled, s.mono)
+		}
+		s.out, s.pos = s.out[:0], 0
+		for _, v := range s.resampled {
+			s.out = binary.LittleEndian.AppendUint16(s.out, uint16(toInt16(v)))
+		}
+	}
+	n := copy(p, s.out[s.pos:])
+	s.pos += n
+	return n, nil
+}
+
+func (s *pcmStream) Close() error {
+	return nil
+}
+
+// pcmBlocks reads interleaved PCM of a fixed format from r and hands it to a pcmStream
+// one read at a time, keeping a partial frame for the next read.
+type pcmBlocks struct {
+	r        io.Reader
+	format   PCMFormat
+	in       []byte
+	buffered int // bytes of in that hold a partial frame from the previous read
+	block    []float64
+	err      error
+}
+
+func newPCMBlocks(r io.Reader, format PCMFormat) *pcmBlocks {
+	return &pcmBlocks{
+		r:      r,
+		format: format,
+		in:     make([]byte, pcmBlockFrames*format.SampleFormat.BytesPerSample()*format.Channels),
+	}
+}
+
+// next returns the whole frames that the next read of r completes, normalized to [-1, 1].
+// Reading stops at the first error, which is returned once the frames before it are.
+func (b *pcmBlocks) next() ([]float64, error) {
+	bytesPerSample := b.format.SampleFormat.BytesPerSample()
+	frameBytes := bytesPerSample * b.format.Channels
+	for b.err == nil {
+		n, err := b.r.Read(b.in[b.buffered:])
+		b.err = err
+		n += b.buffered
+		usable := n / frameBytes * frameBytes
+		b.block = b.block[:0]
+		for i := 0; i < usable; i += bytesPerSample {
+			b.block = append(b.block, b.format.SampleFormat.decode(b.in[i:i+bytesPerSample]))
+		}
+		b.buffered = copy(b.in, b.in[usable:n])
+		if len(b.block) > 0 {
+			return b.block, nil
+		}
+	}
+	return nil, b.err
+}
+
+// ConvertInt16PCM is ConvertPCM for interleaved signed 16-bit samples.
+func ConvertInt16PCM(samples []int16, rate, channels int) ([]int16, error) {
+	if rate == sampleRate && channels == 1 {
+		return samples, nil
+	}
+	return ConvertPCM(int16ToFloat(samples), rate, channels)
+}
+
+func int16ToFloat(samples []int16) []float64 {
+	out := make([]float64, len(samples))
+	for i, v := range samples {
+		out[i] = float64(v) / (1 << 15)
+	}
+	return out
+}
+
+// floatToInt16 converts normalized samples to signed 16-bit, clipping out-of-range values.
+func floatToInt16(samples []float64) []int16 {
+	out := make([]int16, len(samples))
+	for i, v := range samples {
+		out[i] = toInt16(v)
+	}
+	return out
+}
+
+func toInt16(v float64) int16 {
+	v = math.Round(v * (1 << 15))
+	return int16(math.Max(math.MinInt16, math.Min(math.MaxInt16, v)))
+}
+
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	return math.Sin(math.Pi*x) / (math.Pi * x)
+}
+
+// besselI0 evaluates the zeroth-order modified Bessel function of the first kind.
+func besselI0(x float64) float64 {
+	sum, term := 1.0, 1.0
+	halfXSquared := x * x / 4
+	for k := 1; term > besselI0Convergence*sum; k++ {
+		term *= halfXSquared / float64(k*k)
+		sum += term
+	}
+	return sum
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
@@ -0,0 +1,223 @@
+package goshazam
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"math/rand"
+	"os/exec"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// resampleFixtureTones are the in-band tones of the stereo fixtures, per channel.
+var resampleFixtureTones = [2][]float64{{440, 1234}, {440, 3100}}
+
+// resampleFixture returns d of interleaved stereo at rate: different in-band tones
+// on each channel, plus a 10 kHz tone on both that 16 kHz output cannot carry and
+// the anti-aliasing filter must remove.
+func resampleFixture(rate int, d time.Duration) []float64 {
+	frames := int(d.Seconds() * float64(rate))
+	samples := make([]float64, frames*2)
+	for i := 0; i < frames; i++ {
+		t := float64(i) / float64(rate)
+		for c, tones := range resampleFixtureTones {
+			v := 0.1 * math.Sin(2*math.Pi*10000*t)
+			for _, f := range tones {
+				v += 0.3 * math.Sin(2*math.Pi*f*t)
+			}
+			samples[i*2+c] = v
+		}
+	}
+	return samples
+}
+
+// idealResample returns what resampling the fixture to 16 kHz mono should produce:
+// its in-band tones synthesized at 16 kHz directly and averaged over the channels.
+func idealResample(d time.Duration) []float64 {
+	samples := make([]float64, windowSamples(d))
+	for i := range samples {
+		t := float64(i) / sampleRate
+		for _, tones := range resampleFixtureTones {
+			for _, f := range tones {
+				samples[i] += 0.3 * math.Sin(2*math.Pi*f*t) / 2
+			}
+		}
+	}
+	return samples
+}
+
+// snr returns the ratio in dB of reference to the difference between got and
+// reference, leaving out margin samples at both ends where the filters run into silence.
+func snr(got []int16, reference []float64, margin int) float64 {
+	var signal, noise float64
+	for i := margin; i < min(len(got), len(reference))-margin; i++ {
+		want := reference[i] * (1 << 15)
+		signal += want * want
+		noise += (float64(got[i]) - want) * (float64(got[i]) - want)
+	}
+	return 10 * math.Log10(signal/noise)
+}
+
+func TestConvertPCMMatchesIdeal(t *testing.T) {
+	const d = 2 * time.Second
+	reference := idealResample(d)
+	for _, rate := range []int{44100, 48000} {
+		got, err := ConvertPCM(resampleFixture(rate, d), rate, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(reference) {
+			t.Errorf("%d Hz: got %d samples, want %d", rate, len(got), len(reference))
+		}
+		if ratio := snr(got, reference, sampleRate/10); ratio < 80 {
+			t.Errorf("%d Hz: SNR against the ideal output is %.1f dB, want at least 80 dB", rate, ratio)
+		}
+	}
+}
+
+// signaturePartial is a sine that fades in and out once.
+type signaturePartial struct {
+	freq, phase, amp float64
+	on, off          float64 // seconds
+}
+
+// signaturePartials are the partials of signatureFixture, spread over the bands the
+// signature keeps so that it finds peaks in every pass.
+var signaturePartials = func() []signaturePartial {
+	r := rand.New(rand.NewSource(7))
+	partials := make([]signaturePartial, 120)
+	for i := range partials {
+		on := r.Float64() * 5
+		partials[i] = signaturePartial{250 + r.Float64()*6500, r.Float64() * 2 * math.Pi, 0.02 + 0.03*r.Float64(), on, on + 0.2 + r.Float64()}
+	}
+	return partials
+}()
+
+// signatureFixture returns d of signaturePartials at rate. The stereo version carries
+// them on both channels with the 10 kHz tone of resampleFixture; the mono one is what
+// resampling it to 16 kHz mono should produce.
+func signatureFixture(rate int, d time.Duration, stereo bool) []float64 {
+	frames := int(d.Seconds() * float64(rate))
+	samples := make([]float64, 0, 2*frames)
+	for i := 0; i < frames; i++ {
+		t := float64(i) / float64(rate)
+		var v float64
+		for _, p := range signaturePartials {
+			if t >= p.on && t <= p.off {
+				v += p.amp * math.Sin(math.Pi*(t-p.on)/(p.off-p.on)) * math.Sin(2*math.Pi*p.freq*t+p.phase)
+			}
+		}
+		if !stereo {
+			samples = append(samples, v)
+			continue
+		}
+		v += 0.1 * math.Sin(2*math.Pi*10000*t)
+		samples = append(samples, v, v)
+	}
+	return samples
+}
+
+// strongPeakRange is how far below the loudest peak of a signature peakRecall still
+// counts peaks. Quieter ones come and go with rounding.
+const strongPeakRange = 2000
+
+// minPeakRecall is the share of strong peaks that resampling must keep. Noise 50 dB
+// below the signal keeps them all, and noise 40 dB below loses about one in ten.
+const minPeakRecall = 0.95
+
+func signatureOf(samples []int16) DecodedSignature {
+	g, err := NewSignatureGeneratorWindow(time.Duration(len(samples)) * time.Second / sampleRate)
+	if err != nil {
+		panic(err)
+	}
+	return g.MakeSignatureFromBuffer(samples)
+}
+
+// peakRecall returns the share of the strong peaks of want that got has in the same
+// band, within one FFT pass and one frequency bin.
+func peakRecall(want, got DecodedSignature) float64 {
+	wantPeaks, gotPeaks := sortedPeaks(want), sortedPeaks(got)
+	var loudest float64
+	for _, p := range wantPeaks {
+		loudest = max(loudest, p.PeakMagnitude)
+	}
+	var strong, found int
+	for _, p := range wantPeaks {
+		if p.PeakMagnitude < loudest-strongPeakRange {
+			continue
+		}
+		strong++
+		for _, q := range gotPeaks {
+			if p.band == q.band && math.Abs(float64(p.FFTPassNumber)-float64(q.FFTPassNumber)) <= 1 &&
+				math.Abs(float64(p.CorrectedPeakFrequencyBin)-float64(q.CorrectedPeakFrequencyBin)) <= 64 {
+				found++
+				break
+			}
+		}
+	}
+	return float64(found) / float64(strong)
+}
+
+func TestConvertPCMKeepsSignaturePeaks(t *testing.T) {
+	const d = 6 * time.Second
+	ideal := floatToInt16(signatureFixture(sampleRate, d, false))
+	want := signatureOf(ideal)
+	for _, rate := range []int{44100, 48000} {
+		got, err := ConvertPCM(signatureFixture(rate, d, true), rate, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if recall := peakRecall(want, signatureOf(got)); recall < minPeakRecall {
+			t.Errorf("%d Hz: kept %.3f of the ideal output's strong peaks, want at least %.2f", rate, recall, minPeakRecall)
+		}
+	}
+
+	// The bar must reject resampling that is noticeably worse than ConvertPCM.
+	r := rand.New(rand.NewSource(1))
+	noisy := make([]int16, len(ideal))
+	for i, v := range ideal {
+		noisy[i] = int16(max(math.MinInt16, min(math.MaxInt16, float64(v)+0.01*0.3*(1<<15)*r.NormFloat64())))
+	}
+	if recall := peakRecall(want, signatureOf(noisy)); recall >= minPeakRecall {
+		t.Errorf("noise 40 dB below the signal kept %.3f of the strong peaks, want less than %.2f", recall, minPeakRecall)
+	}
+}
+
+// TestConvertPCMMatchesFFmpeg compares ConvertPCM with ffmpeg's "-ar 16000 -ac 1"
+// when ffmpeg is installed: the signatures of both outputs must have the same strong peaks.
+func TestConvertPCMMatchesFFmpeg(t *testing.T) {
+	path, err := exec.LookPath(defaultFFmpegPath)
+	if err != nil {
+		t.Skip("ffmpeg not found")
+	}
+	const d = 6 * time.Second
+	for _, rate := range []int{44100, 48000} {
+		fixture := floatToInt16(signatureFixture(rate, d, true))
+		var input bytes.Buffer
+		if err := binary.Write(&input, binary.LittleEndian, fixture); err != nil {
+			t.Fatal(err)
+		}
+		cmd := exec.Command(path, "-hide_banner", "-loglevel", "error",
+			"-f", "s16le", "-ar", strconv.Itoa(rate), "-ac", "2", "-i", "pipe:",
+			"-f", "s16le", "-ar", "16000", "-ac", "1", "pipe:")
+		cmd.Stdin = &input
+		output, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("%d Hz: ffmpeg: %v", rate, err)
+		}
+		want, err := NewSampleReader(bytes.NewReader(output), ffmpegReadChunk).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := ConvertInt16PCM(fixture, rate, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if recall := peakRecall(signatureOf(want), signatureOf(got)); recall < minPeakRecall {
+			t.Errorf("%d Hz: kept %.3f of ffmpeg's strong peaks, want at least %.2f", rate, recall, minPeakRecall)
+		}
+	}
+}
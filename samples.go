@@ -0,0 +1,59 @@
+package goshazam
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// SampleReader reads signed 16-bit little-endian samples, the format ffmpeg
+// produces for the generator, from an io.Reader in fixed-size chunks. It holds
+// a single chunk in memory however long the input is.
+type SampleReader struct {
+	r     io.Reader
+	buf   []byte
+	chunk []int16
+}
+
+// NewSampleReader returns a reader yielding chunks of chunkSize samples, e.g.
+// hopSize to feed SignatureGenerator one step at a time.
+func NewSampleReader(r io.Reader, chunkSize int) *SampleReader {
+	return &SampleReader{
+		r:     r,
+		buf:   make([]byte, chunkSize*2),
+		chunk: make([]int16, chunkSize),
+	}
+}
+
+// Next returns the next chunk of samples. The chunk is only valid until the
+// following call. The last chunk may be shorter than the chunk size; after it
+// Next returns io.EOF. A trailing odd byte is dropped.
+func (s *SampleReader) Next() ([]int16, error) {
+	n, err := io.ReadFull(s.r, s.buf)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, err
+	}
+	count := n / 2
+	if count == 0 {
+		return nil, io.EOF
+	}
+	for i := range s.chunk[:count] {
+		s.chunk[i] = int16(binary.LittleEndian.Uint16(s.buf[i*2:]))
+	}
+	return s.chunk[:count], nil
+}
+
+// ReadAll returns the remaining samples.
+func (s *SampleReader) ReadAll() ([]int16, error) {
+	var samples []int16
+	for {
+		chunk, err := s.Next()
+		if errors.Is(err, io.EOF) {
+			return samples, nil
+		}
+		if err != nil {
+			return samples, err
+		}
+		samples = append(samples, chunk...)
+	}
+}
@@ -0,0 +1,138 @@
+package goshazam
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// peakDelay is how many hops after its FFT a peak is recognized, once the spread
+// spectra around it are known.
+const peakDelay = 46
+
+// ErrInvalidWindow is returned for signature window or hop lengths out of bounds.
+var ErrInvalidWindow = errors.New("invalid signature window")
+
+// SignatureWindow is the signature of one window of a longer recording.
+type SignatureWindow struct {
+	// Offset is where the window starts, measured from the first sample fed to the series.
+	Offset    time.Duration
+	Signature DecodedSignature
+}
+
+// SignatureSeries walks 16 kHz mono audio with a sliding window, e.g. a 6 s window
+// every 3 s, and computes the signature of every window. Each hop of audio is analysed
+// once whatever the overlap: the FFT and spread spectra of a single pass over the
+// audio are shared by all windows, which take the peaks that fall inside them.
+// Because of that, a window that does not start at the beginning of the audio also
+// sees the spectrum of the audio before it, where a standalone signature would start
+// from silence; peaks near its start can therefore differ slightly.
+// A SignatureSeries is not safe for concurrent use.
+type SignatureSeries struct {
+	gen        *SignatureGenerator
+	windowHops int
+	stepHops   int
+	next       int // first hop of the next window
+	emittedEnd int // hop after the last emitted window
+	samples    int64
+}
+
+// NewSignatureSeries returns a series of windows of the given length, starting every
+// hop. Both are rounded to multiples of 8 ms, the generator's hop. The window must be
+// within MinSignatureWindow and MaxSignatureWindow.
+func NewSignatureSeries(window, hop time.Duration) (*SignatureSeries, error) {
+	if err := CheckSignatureWindow(window); err != nil {
+		return nil, err
+	}
+	windowHops := durationToHops(window)
+	stepHops := durationToHops(hop)
+	if stepHops <= 0 {
+		return nil, fmt.Errorf("%w: hop of %v", ErrInvalidWindow, hop)
+	}
+	return &SignatureSeries{
+		gen:        NewSignatureGenerator(),
+		windowHops: windowHops,
+		stepHops:   stepHops,
+	}, nil
+}
+
+func durationToHops(d time.Duration) int {
+	return int(math.Round(d.Seconds() * sampleRate / hopSize))
+}
+
+// Feed analyses the next chunk of 16 kHz mono samples, which may have any length, and
+// returns the windows it completed in order.
+func (s *SignatureSeries) Feed(samples []int16) []SignatureWindow {
+	s.gen.feed(samples)
+	s.samples += int64(len(samples))
+
+	var windows []SignatureWindow
+	for s.next+s.windowHops <= s.hops() {
+		windows = append(windows, s.window(s.next, s.windowHops*hopSize))
+		s.emittedEnd = s.next + s.windowHops
+		s.next += s.stepHops
+	}
+	s.prune()
+	return windows
+}
+
+// Flush returns a last, shorter window with the audio after the last complete one,
+// if there is any, and ends the series.
+func (s *SignatureSeries) Flush() []SignatureWindow {
+	defer s.gen.reset()
+	if s.hops() <= s.emittedEnd || s.next >= s.hops() {
+		return nil
+	}
+	return []SignatureWindow{s.window(s.next, int(s.samples-int64(s.next*hopSize)))}
+}
+
+func (s *SignatureSeries) hops() int {
+	return int(s.gen.numSpreadFFTsDone)
+}
+
+// window builds the signature of the samples starting at hop start. Its peaks are
+// those a standalone generator would have recognized by the end of the window.
+func (s *SignatureSeries) window(start, samples int) SignatureWindow {
+	signature := DecodedSignature{
+		SampleRateHz:              sampleRate,
+		NumberSamples:             uint32(samples),
+		FrequencyBandToSoundPeaks: make(map[FrequencyBand][]FrequencyPeak),
+	}
+	last := start + samples/hopSize - peakDelay
+	for band, peaks := range s.gen.signature.FrequencyBandToSoundPeaks {
+		for _, peak := range peaks {
+			if pass := int(peak.FFTPassNumber); pass >= start && pass <= last {
+				peak.FFTPassNumber -= uint32(start)
+				signature.FrequencyBandToSoundPeaks[band] = append(signature.FrequencyBandToSoundPeaks[band], peak)
+			}
+		}
+	}
+	return SignatureWindow{
+		Offset:    time.Duration(start*hopSize) * time.Second / sampleRate,
+		Signature: signature,
+	}
+}
+
+// prune forgets the peaks before the next window, which no window needs any more.
+func (s *SignatureSeries) prune() {
+	for band, peaks := range s.gen.signature.FrequencyBandToSoundPeaks {
+		kept := peaks[:0]
+		for _, peak := range peaks {
+			if int(peak.FFTPassNumber) >= s.next {
+				kept = append(kept, peak)
+			}
+		}
+		s.gen.signature.FrequencyBandToSoundPeaks[band] = kept
+	}
+}
+
+// MakeSignatureSeries computes the signatures of sliding windows over 16 kHz mono
+// samples, ending with a shorter window for the audio after the last complete one.
+func MakeSignatureSeries(samples []int16, window, hop time.Duration) ([]SignatureWindow, error) {
+	series, err := NewSignatureSeries(window, hop)
+	if err != nil {
+		return nil, err
+	}
+	return append(series.Feed(samples), series.Flush()...), nil
+}
@@ -0,0 +1,97 @@
+package goshazam
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	// DefaultSilenceThreshold is the level, in dBFS, below which audio counts as silence.
+	DefaultSilenceThreshold = -50.0
+	// DefaultMaxSilenceSkip is how much leading silence Recognize skips at most.
+	DefaultMaxSilenceSkip = 30 * time.Second
+	// silenceFrameSize is the length of the frames whose RMS level is measured, 64 ms at 16 kHz.
+	silenceFrameSize = 1024
+)
+
+// ErrTooQuiet is returned when the audio to fingerprint is entirely below the silence threshold.
+var ErrTooQuiet = errors.New("audio is too quiet to recognize")
+
+// ErrNoAudio is returned when decoding yields no samples at all, e.g. for an offset past the end of the input.
+var ErrNoAudio = errors.New("no audio decoded")
+
+// FirstSound returns the index of the first frame of 16 kHz mono samples whose
+// RMS level reaches thresholdDB (in dBFS), or -1 when all of them are quieter.
+// The level is measured around the frame's mean, so a DC offset is not mistaken
+// for sound. A threshold of math.Inf(-1) treats everything as sound.
+func FirstSound(samples []int16, thresholdDB float64) int {
+	threshold := dbToAmplitude(thresholdDB) * (1 << 15)
+	// Compare variances so no frame needs a square root or a logarithm.
+	minVariance := threshold * threshold
+	for start := 0; start < len(samples); start += silenceFrameSize {
+		frame := samples[start:min(start+silenceFrameSize, len(samples))]
+		var sum, energy float64
+		for _, sample := range frame {
+			sum += float64(sample)
+			energy += float64(sample) * float64(sample)
+		}
+		n := float64(len(frame))
+		mean := sum / n
+		if variance := max(energy/n-mean*mean, 0); variance >= minVariance {
+			return start
+		}
+	}
+	return -1
+}
+
+// SkipSilence drops the leading silence of 16 kHz mono samples, but no more than
+// maxSkip of it, and then returns at most DefaultSignatureWindow of audio. It fails
+// with ErrTooQuiet when that window is entirely below thresholdDB.
+func SkipSilence(samples []int16, thresholdDB float64, maxSkip time.Duration) ([]int16, error) {
+	return skipSilence(samples, thresholdDB, maxSkip, DefaultSignatureWindow)
+}
+
+// skipSilence is SkipSilence with a window of the given length.
+func skipSilence(samples []int16, thresholdDB float64, maxSkip, windowLength time.Duration) ([]int16, error) {
+	start := FirstSound(samples, thresholdDB)
+	if start < 0 {
+		return nil, ErrTooQuiet
+	}
+	start = min(start, int(maxSkip.Seconds()*sampleRate))
+	window := samples[start:min(start+windowSamples(windowLength), len(samples))]
+	// Skipping stopped at maxSkip, so the window may still be silent.
+	if FirstSound(window, thresholdDB) < 0 {
+		return nil, ErrTooQuiet
+	}
+	return window, nil
+}
+
+// soundDetector tells whether audio that arrives in pieces has sound, measuring the
+// same frames as FirstSound over all of it.
+type soundDetector struct {
+	thresholdDB float64
+	frame       []int16
+	found       bool
+}
+
+func newSoundDetector(thresholdDB float64) *soundDetector {
+	return &soundDetector{thresholdDB: thresholdDB, frame: make([]int16, 0, silenceFrameSize)}
+}
+
+// write measures the next samples.
+func (d *soundDetector) write(samples []int16) {
+	for len(samples) > 0 && !d.found {
+		n := min(len(samples), silenceFrameSize-len(d.frame))
+		d.frame = append(d.frame, samples[:n]...)
+		samples = samples[n:]
+		if len(d.frame) == silenceFrameSize {
+			d.found = FirstSound(d.frame, d.thresholdDB) == 0
+			d.frame = d.frame[:0]
+		}
+	}
+}
+
+// sound reports whether a frame written so far has sound, counting a trailing partial frame.
+func (d *soundDetector) sound() bool {
+	return d.found || len(d.frame) > 0 && FirstSound(d.frame, d.thresholdDB) == 0
+}
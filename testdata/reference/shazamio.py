@@ -0,0 +1,50 @@
+"""Writes shazamio's signatures of the corpus for TestReferenceSignatures.
+
+For every <name>.s16 here, raw signed 16-bit little-endian 16 kHz mono PCM written
+by "go test -run TestReferenceSignatures -update", it writes <name>.peaks, one peak
+per line as "band pass bin magnitude", and <name>.sig, the encoded signature.
+
+    pip install shazamio==0.4.0.1
+    python3 testdata/reference/shazamio.py
+
+shazamio 0.4 is the last release with the pure Python generator, a port of SongRec's.
+"""
+
+import array
+import pathlib
+import sys
+
+from shazamio.algorithm import SignatureGenerator
+
+
+def signature(samples):
+    generator = SignatureGenerator()
+    # Fingerprint the whole input as one signature, as MakeSignatureFromBuffer does,
+    # instead of stopping after a few seconds.
+    generator.MAX_TIME_SECONDS = len(samples) / 16000 + 1
+    generator.MAX_PEAKS = sys.maxsize
+    generator.feed_input(list(samples))
+    return generator.get_next_signature()
+
+
+def main():
+    here = pathlib.Path(__file__).parent
+    for path in sorted(here.glob("*.s16")):
+        samples = array.array("h")
+        samples.frombytes(path.read_bytes())
+        if sys.byteorder != "little":
+            samples.byteswap()
+        result = signature(samples)
+
+        lines = []
+        for band in sorted(result.frequency_band_to_sound_peaks, key=lambda b: b.value):
+            for peak in result.frequency_band_to_sound_peaks[band]:
+                lines.append("%d %d %d %.9f\n" % (
+                    band.value, peak.fft_pass_number, peak.corrected_peak_frequency_bin, peak.peak_magnitude))
+        path.with_suffix(".peaks").write_text("".join(lines))
+        path.with_suffix(".sig").write_bytes(result.encode_to_binary())
+        print(path.stem, len(lines), "peaks")
+
+
+if __name__ == "__main__":
+    main()
@@ -0,0 +1,242 @@
+package goshazam
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DefaultMaxDownloadSize is how many bytes RecognizeURL downloads at most.
+	DefaultMaxDownloadSize = 64 << 20
+	// httpSkipAhead is how far a forward seek reads through the current response
+	// instead of starting a new ranged request.
+	httpSkipAhead = 64 << 10
+	// httpRangeSize is how many bytes a ranged request asks for, so that the server
+	// sends little more than the decoder reads.
+	httpRangeSize = 512 << 10
+)
+
+// ErrDownloadTooLarge is returned when RecognizeURL needs more data than the maximum download size.
+var ErrDownloadTooLarge = errors.New("download exceeds the maximum size")
+
+// httpReader reads a remote file. When the server supports ranges it can seek,
+// and the file is read in ranges of httpRangeSize, starting a new one at the end
+// of the last or wherever a seek moved to.
+type httpReader struct {
+	ctx      context.Context
+	client   *ShazamClient
+	url      string
+	body     io.ReadCloser
+	bodyPos  int64 // position of the next byte of body
+	bodyEnd  int64 // position after the last byte of body, math.MaxInt64 when it runs to the end
+	pos      int64
+	size     int64 // -1 when unknown
+	ranges   bool
+	received int64
+	max      int64
+}
+
+// openURL starts downloading rawURL and finds out whether the server supports ranges.
+func (c *ShazamClient) openURL(ctx context.Context, rawURL string, maxSize int64) (*httpReader, error) {
+	h := &httpReader{ctx: ctx, client: c, url: rawURL, size: -1, max: maxSize}
+	if err := h.open(0); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func (h *httpReader) open(pos int64) error {
+	req, err := http.NewRequestWithContext(h.ctx, http.MethodGet, h.url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("User-Agent", h.client.getRandomUserAgent())
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", pos, pos+httpRangeSize-1))
+	// Compressed responses cannot be addressed by byte ranges.
+	req.Header.Set("Accept-Encoding", "identity")
+
+	resp, err := h.client.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	h.body, h.bodyPos, h.bodyEnd = resp.Body, pos, math.MaxInt64
+	switch {
+	case resp.StatusCode == http.StatusPartialContent:
+		h.ranges = true
+		first, last, total := parseContentRange(resp.Header.Get("Content-Range"))
+		if total >= 0 {
+			h.size = total
+		}
+		switch {
+		case first == pos && last >= first:
+			h.bodyEnd = last + 1
+		case resp.ContentLength >= 0:
+			h.bodyEnd = pos + resp.ContentLength
+		}
+	case resp.StatusCode == http.StatusOK && pos == 0:
+		h.size = resp.ContentLength
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		// The range starts at or after the end of the file.
+		resp.Body.Close()
+		h.body = http.NoBody
+	default:
+		resp.Body.Close()
+		h.body = http.NoBody
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// parseContentRange returns the positions of the first and the last byte and the complete
+// length from a "bytes first-last/length" header, or -1 for each that is missing.
+func parseContentRange(header string) (first, last, total int64) {
+	first, last, total = -1, -1, -1
+	spec, ok := strings.CutPrefix(header, "bytes ")
+	if !ok {
+		return
+	}
+	span, length, ok := strings.Cut(spec, "/")
+	if !ok {
+		return
+	}
+	if size, err := strconv.ParseInt(length, 10, 64); err == nil {
+		total = size
+	}
+	from, to, ok := strings.Cut(span, "-")
+	if !ok {
+		return
+	}
+	a, errA := strconv.ParseInt(from, 10, 64)
+	b, errB := strconv.ParseInt(to, 10, 64)
+	if errA == nil && errB == nil {
+		first, last = a, b
+	}
+	return
+}
+
+func (h *httpReader) Read(p []byte) (int, error) {
+	for {
+		if h.size >= 0 && h.pos >= h.size {
+			return 0, io.EOF
+		}
+		if h.bodyPos != h.pos || h.bodyPos >= h.bodyEnd {
+			if err := h.reposition(); err != nil {
+				return 0, err
+			}
+		}
+		n, err := h.body.Read(p)
+		h.pos += int64(n)
+		h.bodyPos += int64(n)
+		if limitErr := h.count(int64(n)); limitErr != nil {
+			return n, limitErr
+		}
+		// The range ended, not the file; go on with the next one.
+		if errors.Is(err, io.EOF) && h.bodyPos >= h.bodyEnd {
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+// reposition moves the response body to pos, reading through short gaps within the
+// current range and requesting a new range otherwise.
+func (h *httpReader) reposition() error {
+	if gap := h.pos - h.bodyPos; gap > 0 && (!h.ranges || gap <= httpSkipAhead && h.pos < h.bodyEnd) {
+		n, err := io.CopyN(io.Discard, h.body, gap)
+		h.bodyPos += n
+		if limitErr := h.count(n); limitErr != nil {
+			return limitErr
+		}
+		return err
+	}
+	if !h.ranges {
+		return fmt.Errorf("cannot seek back to byte %d: server does not support ranges", h.pos)
+	}
+	h.body.Close()
+	return h.open(h.pos)
+}
+
+func (h *httpReader) count(n int64) error {
+	h.received += n
+	if h.received > h.max {
+		return fmt.Errorf("%w of %d bytes", ErrDownloadTooLarge, h.max)
+	}
+	return nil
+}
+
+func (h *httpReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += h.pos
+	case io.SeekEnd:
+		if h.size < 0 {
+			return 0, errors.New("cannot seek from the end: size unknown")
+		}
+		offset += h.size
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("negative position %d", offset)
+	}
+	h.pos = offset
+	return offset, nil
+}
+
+func (h *httpReader) Close() error {
+	return h.body.Close()
+}
+
+// reader returns h as an io.ReadSeeker when the server supports ranges, and
+// hides Seek otherwise so decoders read through the audio they skip.
+func (h *httpReader) reader() io.Reader {
+	if h.ranges {
+		return h
+	}
+	return struct{ io.Reader }{h}
+}
+
+// RecognizeURL downloads audio from rawURL and returns the recognition result.
+// Only as much of the file as the decoder needs for the selected window is
+// downloaded: when the server supports ranges, the file is requested in ranges
+// of a few hundred kilobytes, and skipping to the offset starts a range there
+// instead of reading through the audio before it. Formats without a native
+// decoder are piped into ffmpeg, which reads them from the start, so for those
+// the audio before the offset is downloaded too. When the window does not start
+// with sound, the audio after it is fetched by a second download. Each download
+// fails with ErrDownloadTooLarge after WithMaxDownloadSize bytes.
+func (c *ShazamClient) RecognizeURL(ctx context.Context, rawURL string, opts ...RecognizeOption) (*RecognizeResult, error) {
+	o, err := newRecognizeOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	// The file name helps to pick a decoder when sniffing the content fails.
+	var name string
+	if u, err := url.Parse(rawURL); err == nil {
+		name = path.Base(u.Path)
+	}
+	return c.recognizeDecoded(ctx, o, func(opts DecodeOptions) (io.ReadCloser, error) {
+		body, err := c.openURL(ctx, rawURL, o.maxDownloadSize)
+		if err != nil {
+			return nil, fmt.Errorf("error fetching audio: %w", err)
+		}
+		pcm, err := c.decoders.decodeReaderStream(ctx, body.reader(), name, opts)
+		if err != nil {
+			body.Close()
+			return nil, fmt.Errorf("error decoding audio: %w", err)
+		}
+		return decodeErrorReader{&closeBoth{ReadCloser: pcm, source: body}}, nil
+	})
+}
@@ -0,0 +1,173 @@
+package goshazam
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fileServer serves content and counts the bytes it sends. With ranges it answers
+// "bytes=first-last" requests like a static file server; with unknownSize it leaves
+// the complete length out of Content-Range.
+type fileServer struct {
+	content     []byte
+	ranges      bool
+	unknownSize bool
+
+	mu       sync.Mutex
+	served   int64
+	statuses []int
+}
+
+func (s *fileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, status := s.content, http.StatusOK
+	if spec := r.Header.Get("Range"); s.ranges && spec != "" {
+		size := int64(len(s.content))
+		first, last := int64(0), size-1
+		if _, err := fmt.Sscanf(spec, "bytes=%d-%d", &first, &last); err != nil {
+			if _, err := fmt.Sscanf(spec, "bytes=%d-", &first); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		total := strconv.FormatInt(size, 10)
+		if s.unknownSize {
+			total = "*"
+		}
+		if first >= size {
+			w.Header().Set("Content-Range", "bytes */"+total)
+			s.record(http.StatusRequestedRangeNotSatisfiable, 0)
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		last = min(last, size-1)
+		body, status = s.content[first:last+1], http.StatusPartialContent
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%s", first, last, total))
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(status)
+	n, _ := w.Write(body)
+	s.record(status, int64(n))
+}
+
+func (s *fileServer) record(status int, n int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.served += n
+	s.statuses = append(s.statuses, status)
+}
+
+// decodeURL decodes the selected window of the WAV file that server serves, downloading at most maxSize bytes.
+func decodeURL(t *testing.T, server *fileServer, maxSize int64, opts DecodeOptions) ([]int16, error) {
+	t.Helper()
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	c := NewShazamClient(WithHTTPClient(ts.Client()))
+
+	body, err := c.openURL(context.Background(), ts.URL+"/audio.wav", maxSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+	pcm, err := c.decoders.decodeReaderStream(context.Background(), body.reader(), "audio.wav", opts)
+	if err != nil {
+		return nil, err
+	}
+	return readDecoded(pcm)
+}
+
+func TestHTTPReaderDownloadsWindow(t *testing.T) {
+	wav := encodeWAV(44100, 0, time.Minute)
+	opts := DecodeOptions{Offset: 30 * time.Second, Duration: 6 * time.Second}
+	want, err := DecodeWAV(bytes.NewReader(wav), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const bytesPerSecond = 44100 * 4
+	windowBytes := int64(opts.Duration.Seconds() * bytesPerSecond)
+	throughWindow := int64((opts.Offset + opts.Duration).Seconds() * bytesPerSecond)
+
+	t.Run("ranges", func(t *testing.T) {
+		server := &fileServer{content: wav, ranges: true}
+		got, err := decodeURL(t, server, 2*windowBytes, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("got %d samples, want the %d decoded from the file", len(got), len(want))
+		}
+		// The header, then the window in whole ranges.
+		if limit := windowBytes + 3*httpRangeSize; server.served > limit {
+			t.Errorf("server sent %d bytes, want at most %d", server.served, limit)
+		}
+	})
+
+	t.Run("no ranges", func(t *testing.T) {
+		server := &fileServer{content: wav}
+		got, err := decodeURL(t, server, DefaultMaxDownloadSize, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("got %d samples, want the %d decoded from the file", len(got), len(want))
+		}
+		// The audio before the offset is read through.
+		if server.served < throughWindow {
+			t.Errorf("server sent %d bytes, want at least the %d before the end of the window", server.served, throughWindow)
+		}
+	})
+
+	t.Run("no ranges too large", func(t *testing.T) {
+		server := &fileServer{content: wav}
+		if _, err := decodeURL(t, server, 2*windowBytes, opts); !errors.Is(err, ErrDownloadTooLarge) {
+			t.Errorf("got error %v, want ErrDownloadTooLarge", err)
+		}
+	})
+}
+
+func TestHTTPReaderPastEnd(t *testing.T) {
+	wav := encodeWAV(44100, 0, 2*time.Second)
+	// A streamed WAV leaves the data size open, so only the server can tell where the file ends.
+	binary.LittleEndian.PutUint32(wav[40:], math.MaxUint32)
+	server := &fileServer{content: wav, ranges: true, unknownSize: true}
+
+	got, err := decodeURL(t, server, DefaultMaxDownloadSize, DecodeOptions{Offset: time.Minute, Duration: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Errorf("got %d samples after the end of the file, want none", len(got))
+	}
+	if !slices.Contains(server.statuses, http.StatusRequestedRangeNotSatisfiable) {
+		t.Errorf("server answered %v, want a request past the end", server.statuses)
+	}
+}
+
+func TestParseContentRange(t *testing.T) {
+	for _, tt := range []struct {
+		header             string
+		first, last, total int64
+	}{
+		{"bytes 0-499/1234", 0, 499, 1234},
+		{"bytes 500-999/*", 500, 999, -1},
+		{"bytes */1234", -1, -1, 1234},
+		{"", -1, -1, -1},
+		{"items 0-1/2", -1, -1, -1},
+	} {
+		first, last, total := parseContentRange(tt.header)
+		if first != tt.first || last != tt.last || total != tt.total {
+			t.Errorf("parseContentRange(%q) = %d, %d, %d, want %d, %d, %d", tt.header, first, last, total, tt.first, tt.last, tt.total)
+		}
+	}
+}
@@ -0,0 +1,1236 @@
+package goshazam
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"gonum.org/v1/gonum/dsp/fourier"
+	"io"
+	"math"
+	"sort"
+)
+
+const (
+	vorbisPacketIdentification = 1
+	vorbisPacketComment        = 3
+	vorbisPacketSetup          = 5
+	vorbisCodebookSync         = 0x564342
+)
+
+// ErrInvalidVorbis is returned when a Vorbis header or audio packet cannot be decoded.
+var ErrInvalidVorbis = errors.New("invalid Vorbis data")
+
+// vorbisInverseDBTable is floor1_inverse_dB_table from the Vorbis I
+// specification: 256 steps spaced geometrically from 1.0649863e-07 to 1.
+var vorbisInverseDBTable = func() [256]float64 {
+	var table [256]float64
+	for i := range table {
+		table[i] = math.Pow(1.0649863e-07, float64(255-i)/255)
+	}
+	return table
+}()
+
+var vorbisFloor1Ranges = [4]int{256, 128, 86, 64}
+
+// VorbisDecoder is the native Decoder for Ogg Vorbis streams.
+type VorbisDecoder struct{}
+
+func (VorbisDecoder) Decode(_ context.Context, r io.Reader, opts DecodeOptions) ([]int16, error) {
+	return DecodeVorbis(r, opts)
+}
+
+// DecodeStream decodes the selected window of r a packet at a time.
+func (VorbisDecoder) DecodeStream(_ context.Context, r io.Reader, opts DecodeOptions) (io.ReadCloser, error) {
+	return decodeVorbisStream(r, opts)
+}
+
+func (VorbisDecoder) Probe(_ context.Context, path string) (*MediaInfo, error) {
+	return probeFile(path, probeVorbis)
+}
+
+// probeVorbis reads the header packets and takes the duration from the
+// granule position of the stream's last page.
+func probeVorbis(r io.ReadSeeker, size int64) (*MediaInfo, error) {
+	v, err := newVorbisReader(r)
+	if err != nil {
+		return nil, err
+	}
+	granule, err := oggLastGranule(r, size, v.ogg.serial)
+	if err != nil {
+		return nil, err
+	}
+
+	duration := framesDuration(granule, v.sampleRate)
+	bitrate := v.bitrate
+	if bitrate <= 0 {
+		bitrate = averageBitrate(size, duration)
+	}
+	return &MediaInfo{
+		Duration:   duration,
+		Container:  "ogg",
+		Codec:      "vorbis",
+		SampleRate: v.sampleRate,
+		Channels:   v.channels,
+		Bitrate:    bitrate,
+		Tags:       parseVorbisComments(v.comments),
+	}, nil
+}
+
+// IsOggVorbis reports whether header starts with an Ogg page carrying a Vorbis identification header.
+func IsOggVorbis(header []byte) bool {
+	if !IsOgg(header) || len(header) < oggHeaderSize {
+		return false
+	}
+	segments := int(header[26])
+	start := oggHeaderSize + segments
+	return len(header) >= start+7 && isVorbisHeader(header[start:], vorbisPacketIdentification)
+}
+
+func isVorbisHeader(packet []byte, packetType byte) bool {
+	return len(packet) >= 7 && packet[0] == packetType && string(packet[1:7]) == "vorbis"
+}
+
+// DecodeVorbis decodes the selected window of an Ogg Vorbis stream into
+// 16 kHz mono samples. Audio packets before the offset are skipped without
+// running the synthesis.
+func DecodeVorbis(r io.Reader, opts DecodeOptions) ([]int16, error) {
+	pcm, err := decodeVorbisStream(r, opts)
+	if err != nil {
+		return nil, err
+	}
+	return readDecoded(pcm)
+}
+
+func decodeVorbisStream(r io.Reader, opts DecodeOptions) (*pcmStream, error) {
+	if err := opts.checkSingleStream(); err != nil {
+		return nil, err
+	}
+	v, err := newVorbisReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	channels := v.channels
+	startFrame, frameCount := opts.frames(v.sampleRate)
+	var position int64
+	return newPCMStream(func() ([]float64, error) {
+		for frameCount == 0 || position < startFrame+frameCount {
+			packet, err := v.ogg.nextPacket()
+			if err != nil {
+				return nil, err
+			}
+
+			n, err := v.packetSize(packet.data)
+			if err != nil {
+				continue
+			}
+			produced := int64(0)
+			if v.prevN > 0 {
+				produced = int64(v.prevN/4 + n/4)
+			}
+			// The next packet overlaps this one, so only skip synthesis when neither can reach the window.
+			if position+produced+int64(v.blocksize[1]/2) <= startFrame {
+				v.prevN, v.prevValid = n, false
+				position += produced
+				continue
+			}
+
+			pcm, err := v.decodePacket(packet.data)
+			if err != nil {
+				return nil, err
+			}
+			frames := int64(len(pcm) / channels)
+			if packet.eos && packet.granule >= 0 && position+frames > packet.granule {
+				frames = max(packet.granule-position, 0)
+			}
+			from := max(startFrame-position, 0)
+			to := frames
+			if frameCount > 0 {
+				to = min(to, startFrame+frameCount-position)
+			}
+			position += frames
+			if from < to {
+				return pcm[from*int64(channels) : to*int64(channels)], nil
+			}
+		}
+		return nil, io.EOF
+	}, v.sampleRate, channels)
+}
+
+type vorbisCodebook struct {
+	dimensions int
+	entries    int
+	// tree holds pairs of children; values >= 0 are node indices and values < 0 encode leaf -(entry+1).
+	tree    [][2]int32
+	vectors []float64
+}
+
+type vorbisFloor1 struct {
+	partitionClasses []int
+	classDimensions  []int
+	classSubclasses  []int
+	classMasterbooks []int
+	subclassBooks    [][]int
+	multiplier       int
+	xList            []int
+	sortedOrder      []int
+	lowNeighbor      []int
+	highNeighbor     []int
+}
+
+type vorbisResidue struct {
+	residueType     int
+	begin           int
+	end             int
+	partitionSize   int
+	classifications int
+	classbook       int
+	books           [][8]int
+}
+
+type vorbisMapping struct {
+	couplingMagnitude []int
+	couplingAngle     []int
+	mux               []int
+	submapFloor       []int
+	submapResidue     []int
+}
+
+type vorbisMode struct {
+	blockflag bool
+	mapping   int
+}
+
+// vorbisReader holds the decoder setup and the overlap-add state of one stream.
+type vorbisReader struct {
+	ogg        *oggReader
+	channels   int
+	sampleRate int
+	bitrate    int
+	blocksize  [2]int
+	vendor     string
+	comments   []string
+	codebooks  []vorbisCodebook
+	floors     []vorbisFloor1
+	residues   []vorbisResidue
+	mappings   []vorbisMapping
+	modes      []vorbisMode
+	modeBits   uint
+	imdcts     map[int]*vorbisIMDCT
+	prev       [][]float64
+	prevN      int
+	prevValid  bool
+}
+
+func newVorbisReader(r io.Reader) (*vorbisReader, error) {
+	v := &vorbisReader{imdcts: make(map[int]*vorbisIMDCT)}
+	v.ogg = newOggReader(r, func(first []byte) bool {
+		return isVorbisHeader(first, vorbisPacketIdentification)
+	})
+
+	for _, packetType := range []byte{vorbisPacketIdentification, vorbisPacketComment, vorbisPacketSetup} {
+		packet, err := v.ogg.nextPacket()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, fmt.Errorf("%w: missing header packets", ErrInvalidVorbis)
+			}
+			return nil, err
+		}
+		if !isVorbisHeader(packet.data, packetType) {
+			return nil, fmt.Errorf("%w: expected header packet %d", ErrInvalidVorbis, packetType)
+		}
+		b := newLSBBitReader(packet.data[7:])
+		switch packetType {
+		case vorbisPacketIdentification:
+			err = v.readIdentification(b)
+		case vorbisPacketComment:
+			err = v.readComments(b)
+		case vorbisPacketSetup:
+			err = v.readSetup(b)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+func (v *vorbisReader) readIdentification(b *lsbBitReader) error {
+	version := b.readBits(32)
+	v.channels = int(b.readBits(8))
+	v.sampleRate = int(b.readBits(32))
+	b.readBits(32) // bitrate_maximum
+	v.bitrate = int(int32(b.readBits(32)))
+	b.readBits(32) // bitrate_minimum
+	v.blocksize[0] = 1 << b.readBits(4)
+	v.blocksize[1] = 1 << b.readBits(4)
+	framing := b.readBits(1)
+	switch {
+	case b.eop || version != 0 || framing != 1:
+		return fmt.Errorf("%w: bad identification header", ErrInvalidVorbis)
+	case v.channels == 0 || v.sampleRate == 0:
+		return fmt.Errorf("%w: zero channels or sample rate", ErrInvalidVorbis)
+	case v.blocksize[0] < 64 || v.blocksize[1] > 8192 || v.blocksize[0] > v.blocksize[1]:
+		return fmt.Errorf("%w: invalid block sizes %d/%d", ErrInvalidVorbis, v.blocksize[0], v.blocksize[1])
+	}
+	return nil
+}
+
+func (v *vorbisReader) readComments(b *lsbBitReader) error {
+	v.vendor = b.readString()
+	count := int(b.readBits(32))
+	for i := 0; i < count && !b.eop; i++ {
+		v.comments = append(v.comments, b.readString())
+	}
+	if b.eop {
+		return fmt.Errorf("%w: truncated comment header", ErrInvalidVorbis)
+	}
+	return nil
+}
+
+func (v *vorbisReader) readSetup(b *lsbBitReader) error {
+	v.codebooks = make([]vorbisCodebook, b.readBits(8)+1)
+	for i := range v.codebooks {
+		if err := readVorbisCodebook(b, &v.codebooks[i]); err != nil {
+			return err
+		}
+	}
+
+	timeCount := int(b.readBits(6)) + 1
+	for i := 0; i < timeCount; i++ {
+		if b.readBits(16) != 0 {
+			return fmt.Errorf("%w: nonzero time domain transform", ErrInvalidVorbis)
+		}
+	}
+
+	v.floors = make([]vorbisFloor1, b.readBits(6)+1)
+	for i := range v.floors {
+		floorType := b.readBits(16)
+		if floorType != 1 {
+			return fmt.Errorf("unsupported Vorbis floor type %d", floorType)
+		}
+		if err := v.readFloor1(b, &v.floors[i]); err != nil {
+			return err
+		}
+	}
+
+	v.residues = make([]vorbisResidue, b.readBits(6)+1)
+	for i := range v.residues {
+		if err := v.readResidue(b, &v.residues[i]); err != nil {
+			return err
+		}
+	}
+
+	v.mappings = make([]vorbisMapping, b.readBits(6)+1)
+	for i := range v.mappings {
+		if err := v.readMapping(b, &v.mappings[i]); err != nil {
+			return err
+		}
+	}
+
+	v.modes = make([]vorbisMode, b.readBits(6)+1)
+	for i := range v.modes {
+		v.modes[i].blockflag = b.readBits(1) == 1
+		windowType := b.readBits(16)
+		transformType := b.readBits(16)
+		v.modes[i].mapping = int(b.readBits(8))
+		if windowType != 0 || transformType != 0 || v.modes[i].mapping >= len(v.mappings) {
+			return fmt.Errorf("%w: invalid mode %d", ErrInvalidVorbis, i)
+		}
+	}
+	v.modeBits = ilog(uint32(len(v.modes) - 1))
+
+	if b.readBits(1) != 1 || b.eop {
+		return fmt.Errorf("%w: bad setup header framing", ErrInvalidVorbis)
+	}
+	return nil
+}
+
+func readVorbisCodebook(b *lsbBitReader, c *vorbisCodebook) error {
+	if b.readBits(24) != vorbisCodebookSync {
+		return fmt.Errorf("%w: lost codebook sync", ErrInvalidVorbis)
+	}
+	c.dimensions = int(b.readBits(16))
+	c.entries = int(b.readBits(24))
+	if b.eop || c.entries == 0 {
+		return fmt.Errorf("%w: truncated codebook", ErrInvalidVorbis)
+	}
+
+	lengths := make([]uint8, c.entries)
+	if ordered := b.readBits(1) == 1; ordered {
+		current := 0
+		length := uint8(b.readBits(5)) + 1
+		for current < c.entries {
+			number := int(b.readBits(ilog(uint32(c.entries - current))))
+			if current+number > c.entries || length > 32 {
+				return fmt.Errorf("%w: invalid ordered codebook lengths", ErrInvalidVorbis)
+			}
+			for i := current; i < current+number; i++ {
+				lengths[i] = length
+			}
+			current += number
+			length++
+		}
+	} else {
+		sparse := b.readBits(1) == 1
+		for i := range lengths {
+			if !sparse || b.readBits(1) == 1 {
+				lengths[i] = uint8(b.readBits(5)) + 1
+			}
+		}
+	}
+	if err := c.buildTree(lengths); err != nil {
+		return err
+	}
+
+	lookupType := b.readBits(4)
+	switch lookupType {
+	case 0:
+	case 1, 2:
+		minimum := float32Unpack(uint32(b.readBits(32)))
+		delta := float32Unpack(uint32(b.readBits(32)))
+		valueBits := uint(b.readBits(4)) + 1
+		sequenceP := b.readBits(1) == 1
+		var lookupValues int
+		if lookupType == 1 {
+			lookupValues = lookup1Values(c.entries, c.dimensions)
+		} else {
+			lookupValues = c.entries * c.dimensions
+		}
+		multiplicands := make([]float64, lookupValues)
+		for i := range multiplicands {
+			multiplicands[i] = float64(b.readBits(valueBits))
+		}
+		if b.eop {
+			return fmt.Errorf("%w: truncated codebook lookup table", ErrInvalidVorbis)
+		}
+		c.vectors = make([]float64, c.entries*c.dimensions)
+		for entry := 0; entry < c.entries; entry++ {
+			last := 0.0
+			indexDivisor := 1
+			for i := 0; i < c.dimensions; i++ {
+				var offset int
+				if lookupType == 1 {
+					offset = entry / indexDivisor % lookupValues
+					indexDivisor *= lookupValues
+				} else {
+					offset = entry*c.dimensions + i
+				}
+				value := multiplicands[offset]*delta + minimum + last
+				if sequenceP {
+					last = value
+				}
+				c.vectors[entry*c.dimensions+i] = value
+			}
+		}
+	default:
+		return fmt.Errorf("%w: invalid codebook lookup type %d", ErrInvalidVorbis, lookupType)
+	}
+	return nil
+}
+
+// buildTree assigns Huffman codewords to the entries in order, as the specification describes.
+func (c *vorbisCodebook) buildTree(lengths []uint8) error {
+	c.tree = [][2]int32{{0, 0}}
+	used := 0
+	for _, l := range lengths {
+		if l > 0 {
+			used++
+		}
+	}
+	if used == 0 {
+		return nil
+	}
+	if used == 1 {
+		for entry, l := range lengths {
+			if l > 0 {
+				c.tree[0] = [2]int32{-int32(entry) - 1, -int32(entry) - 1}
+			}
+		}
+		return nil
+	}
+
+	var available [33]uint32
+	first := true
+	for entry, l := range lengths {
+		if l == 0 {
+			continue
+		}
+		var code uint32
+		if first {
+			first = false
+			for i := 1; i <= int(l); i++ {
+				available[i] = 1 << (32 - i)
+			}
+		} else {
+			z := int(l)
+			for z > 0 && available[z] == 0 {
+				z--
+			}
+			if z == 0 {
+				return fmt.Errorf("%w: overspecified Huffman tree", ErrInvalidVorbis)
+			}
+			code = available[z]
+			available[z] = 0
+			for y := int(l); y > z; y-- {
+				available[y] = code + 1<<(32-y)
+			}
+		}
+		c.insert(code, int(l), entry)
+	}
+	return nil
+}
+
+func (c *vorbisCodebook) insert(code uint32, length, entry int) {
+	node := 0
+	for depth := 0; depth < length; depth++ {
+		bit := code >> (31 - depth) & 1
+		if depth == length-1 {
+			c.tree[node][bit] = -int32(entry) - 1
+			return
+		}
+		next := c.tree[node][bit]
+		if next == 0 {
+			next = int32(len(c.tree))
+			c.tree[node][bit] = next
+			c.tree = append(c.tree, [2]int32{})
+		}
+		node = int(next)
+	}
+}
+
+// decodeScalar reads one codeword and returns its entry number, or -1 on a bad code or end of packet.
+func (c *vorbisCodebook) decodeScalar(b *lsbBitReader) int {
+	node := int32(0)
+	for {
+		child := c.tree[node][b.readBits(1)]
+		if b.eop {
+			return -1
+		}
+		if child < 0 {
+			return int(-child - 1)
+		}
+		if child == 0 {
+			// An undefined codeword is treated like the end of the packet.
+			b.eop = true
+			return -1
+		}
+		node = child
+	}
+}
+
+// decodeVector reads one codeword and returns its VQ vector.
+func (c *vorbisCodebook) decodeVector(b *lsbBitReader) []float64 {
+	entry := c.decodeScalar(b)
+	if entry < 0 || c.vectors == nil {
+		return nil
+	}
+	return c.vectors[entry*c.dimensions : (entry+1)*c.dimensions]
+}
+
+func (v *vorbisReader) readFloor1(b *lsbBitReader, f *vorbisFloor1) error {
+	f.partitionClasses = make([]int, b.readBits(5))
+	maxClass := -1
+	for i := range f.partitionClasses {
+		f.partitionClasses[i] = int(b.readBits(4))
+		maxClass = max(maxClass, f.partitionClasses[i])
+	}
+
+	classes := maxClass + 1
+	f.classDimensions = make([]int, classes)
+	f.classSubclasses = make([]int, classes)
+	f.classMasterbooks = make([]int, classes)
+	f.subclassBooks = make([][]int, classes)
+	for i := 0; i < classes; i++ {
+		f.classDimensions[i] = int(b.readBits(3)) + 1
+		f.classSubclasses[i] = int(b.readBits(2))
+		if f.classSubclasses[i] != 0 {
+			f.classMasterbooks[i] = int(b.readBits(8))
+			if f.classMasterbooks[i] >= len(v.codebooks) {
+				return fmt.Errorf("%w: invalid floor masterbook", ErrInvalidVorbis)
+			}
+		}
+		f.subclassBooks[i] = make([]int, 1<<f.classSubclasses[i])
+		for j := range f.subclassBooks[i] {
+			f.subclassBooks[i][j] = int(b.readBits(8)) - 1
+			if f.subclassBooks[i][j] >= len(v.codebooks) {
+				return fmt.Errorf("%w: invalid floor subclass book", ErrInvalidVorbis)
+			}
+		}
+	}
+
+	f.multiplier = int(b.readBits(2)) + 1
+	rangeBits := uint(b.readBits(4))
+	f.xList = []int{0, 1 << rangeBits}
+	for _, class := range f.partitionClasses {
+		for j := 0; j < f.classDimensions[class]; j++ {
+			f.xList = append(f.xList, int(b.readBits(rangeBits)))
+		}
+	}
+	if b.eop || len(f.xList) > 65 {
+		return fmt.Errorf("%w: invalid floor 1 setup", ErrInvalidVorbis)
+	}
+
+	f.sortedOrder = make([]int, len(f.xList))
+	for i := range f.sortedOrder {
+		f.sortedOrder[i] = i
+	}
+	sort.SliceStable(f.sortedOrder, func(i, j int) bool {
+		return f.xList[f.sortedOrder[i]] < f.xList[f.sortedOrder[j]]
+	})
+
+	f.lowNeighbor = make([]int, len(f.xList))
+	f.highNeighbor = make([]int, len(f.xList))
+	for i := 2; i < len(f.xList); i++ {
+		low, high := 0, 1
+		for j := 0; j < i; j++ {
+			if f.xList[j] < f.xList[i] && f.xList[j] > f.xList[low] {
+				low = j
+			}
+			if f.xList[j] > f.xList[i] && f.xList[j] < f.xList[high] {
+				high = j
+			}
+		}
+		f.lowNeighbor[i], f.highNeighbor[i] = low, high
+	}
+	return nil
+}
+
+func (v *vorbisReader) readResidue(b *lsbBitReader, r *vorbisResidue) error {
+	r.residueType = int(b.readBits(16))
+	if r.residueType > 2 {
+		return fmt.Errorf("%w: invalid residue type %d", ErrInvalidVorbis, r.residueType)
+	}
+	r.begin = int(b.readBits(24))
+	r.end = int(b.readBits(24))
+	r.partitionSize = int(b.readBits(24)) + 1
+	r.classifications = int(b.readBits(6)) + 1
+	r.classbook = int(b.readBits(8))
+	if r.classbook >= len(v.codebooks) {
+		return fmt.Errorf("%w: invalid residue classbook", ErrInvalidVorbis)
+	}
+
+	cascade := make([]int, r.classifications)
+	for i := range cascade {
+		lowBits := int(b.readBits(3))
+		highBits := 0
+		if b.readBits(1) == 1 {
+			highBits = int(b.readBits(5))
+		}
+		cascade[i] = highBits<<3 | lowBits
+	}
+	r.books = make([][8]int, r.classifications)
+	for i := range r.books {
+		for j := 0; j < 8; j++ {
+			r.books[i][j] = -1
+			if cascade[i]&(1<<j) != 0 {
+				r.books[i][j] = int(b.readBits(8))
+				if r.books[i][j] >= len(v.codebooks) || v.codebooks[r.books[i][j]].vectors == nil {
+					return fmt.Errorf("%w: invalid residue book", ErrInvalidVorbis)
+				}
+			}
+		}
+	}
+	if b.eop {
+		return fmt.Errorf("%w: truncated residue setup", ErrInvalidVorbis)
+	}
+	return nil
+}
+
+func (v *vorbisReader) readMapping(b *lsbBitReader, m *vorbisMapping) error {
+	if b.readBits(16) != 0 {
+		return fmt.Errorf("%w: invalid mapping type", ErrInvalidVorbis)
+	}
+	submaps := 1
+	if b.readBits(1) == 1 {
+		submaps = int(b.readBits(4)) + 1
+	}
+	if b.readBits(1) == 1 {
+		steps := int(b.readBits(8)) + 1
+		bits := ilog(uint32(v.channels - 1))
+		for i := 0; i < steps; i++ {
+			magnitude := int(b.readBits(bits))
+			angle := int(b.readBits(bits))
+			if magnitude == angle || magnitude >= v.channels || angle >= v.channels {
+				return fmt.Errorf("%w: invalid channel coupling", ErrInvalidVorbis)
+			}
+			m.couplingMagnitude = append(m.couplingMagnitude, magnitude)
+			m.couplingAngle = append(m.couplingAngle, angle)
+		}
+	}
+	if b.readBits(2) != 0 {
+		return fmt.Errorf("%w: reserved mapping bits set", ErrInvalidVorbis)
+	}
+	m.mux = make([]int, v.channels)
+	if submaps > 1 {
+		for i := range m.mux {
+			m.mux[i] = int(b.readBits(4))
+			if m.mux[i] >= submaps {
+				return fmt.Errorf("%w: invalid mapping mux", ErrInvalidVorbis)
+			}
+		}
+	}
+	m.submapFloor = make([]int, submaps)
+	m.submapResidue = make([]int, submaps)
+	for i := 0; i < submaps; i++ {
+		b.readBits(8) // unused time configuration
+		m.submapFloor[i] = int(b.readBits(8))
+		m.submapResidue[i] = int(b.readBits(8))
+		if m.submapFloor[i] >= len(v.floors) || m.submapResidue[i] >= len(v.residues) {
+			return fmt.Errorf("%w: invalid submap", ErrInvalidVorbis)
+		}
+	}
+	return nil
+}
+
+// packetSize returns the block size of an audio packet without decoding it.
+func (v *vorbisReader) packetSize(packet []byte) (int, error) {
+	b := newLSBBitReader(packet)
+	if b.readBits(1) != 0 {
+		return 0, fmt.Errorf("%w: not an audio packet", ErrInvalidVorbis)
+	}
+	mode := int(b.readBits(v.modeBits))
+	if b.eop || mode >= len(v.modes) {
+		return 0, fmt.Errorf("%w: invalid mode number", ErrInvalidVorbis)
+	}
+	if v.modes[mode].blockflag {
+		return v.blocksize[1], nil
+	}
+	return v.blocksize[0], nil
+}
+
+// decodePacket decodes one audio packet and returns the interleaved samples
+// that become final once it is overlapped with the previous packet.
+func (v *vorbisReader) decodePacket(packet []byte) ([]float64, error) {
+	b := newLSBBitReader(packet)
+	if b.readBits(1) != 0 {
+		return nil, nil
+	}
+	modeNumber := int(b.readBits(v.modeBits))
+	if b.eop || modeNumber >= len(v.modes) {
+		return nil, nil
+	}
+	mode := v.modes[modeNumber]
+	mapping := &v.mappings[mode.mapping]
+
+	n := v.blocksize[0]
+	prevLong, nextLong := false, false
+	if mode.blockflag {
+		n = v.blocksize[1]
+		prevLong = b.readBits(1) == 1
+		nextLong = b.readBits(1) == 1
+	}
+	half := n / 2
+
+	// Floors: decode the amplitude values of every channel.
+	floorY := make([][]int, v.channels)
+	floorUsed := make([][]bool, v.channels)
+	noResidue := make([]bool, v.channels)
+	for ch := 0; ch < v.channels; ch++ {
+		floor := &v.floors[mapping.submapFloor[mapping.mux[ch]]]
+		floorY[ch], floorUsed[ch] = v.decodeFloor1(b, floor)
+		noResidue[ch] = floorY[ch] == nil
+	}
+	floorUnused := append([]bool(nil), noResidue...)
+
+	// Nonzero vector propagation for coupled channels.
+	for i := range mapping.couplingMagnitude {
+		m, a := mapping.couplingMagnitude[i], mapping.couplingAngle[i]
+		if !noResidue[m] || !noResidue[a] {
+			noResidue[m], noResidue[a] = false, false
+		}
+	}
+
+	// Residues, decoded per submap.
+	spectra := make([][]float64, v.channels)
+	for ch := range spectra {
+		spectra[ch] = make([]float64, half)
+	}
+	for submap := range mapping.submapResidue {
+		var vectors [][]float64
+		var doNotDecode []bool
+		for ch := 0; ch < v.channels; ch++ {
+			if mapping.mux[ch] == submap {
+				vectors = append(vectors, spectra[ch])
+				doNotDecode = append(doNotDecode, noResidue[ch])
+			}
+		}
+		v.decodeResidue(b, &v.residues[mapping.submapResidue[submap]], vectors, doNotDecode, half)
+	}
+
+	// Inverse coupling.
+	for i := len(mapping.couplingMagnitude) - 1; i >= 0; i-- {
+		magnitude := spectra[mapping.couplingMagnitude[i]]
+		angle := spectra[mapping.couplingAngle[i]]
+		for j := range magnitude {
+			m, a := magnitude[j], angle[j]
+			switch {
+			case m > 0 && a > 0:
+				magnitude[j], angle[j] = m, m-a
+			case m > 0:
+				magnitude[j], angle[j] = m+a, m
+			case a > 0:
+				magnitude[j], angle[j] = m, m+a
+			default:
+				magnitude[j], angle[j] = m-a, m
+			}
+		}
+	}
+
+	// Floor curve, dot product and inverse MDCT with windowing.
+	window := v.window(n, prevLong, nextLong, mode.blockflag)
+	imdct := v.imdct(n)
+	current := make([][]float64, v.channels)
+	curve := make([]float64, half)
+	for ch := 0; ch < v.channels; ch++ {
+		current[ch] = make([]float64, n)
+		if floorUnused[ch] {
+			continue
+		}
+		floor := &v.floors[mapping.submapFloor[mapping.mux[ch]]]
+		renderFloor1(floor, floorY[ch], floorUsed[ch], curve)
+		for i := range spectra[ch] {
+			spectra[ch][i] *= curve[i]
+		}
+		imdct.transform(spectra[ch], current[ch])
+		for i := range current[ch] {
+			current[ch][i] *= window[i]
+		}
+	}
+
+	// Overlap-add: the result runs from the center of the previous window to the center of this one.
+	var out []float64
+	if v.prevN > 0 {
+		frames := v.prevN/4 + n/4
+		out = make([]float64, frames*v.channels)
+		for j := 0; j < frames; j++ {
+			c := n/4 - v.prevN/4 + j
+			p := j + v.prevN/2
+			for ch := 0; ch < v.channels; ch++ {
+				var sample float64
+				if v.prevValid && p < v.prevN {
+					sample += v.prev[ch][p]
+				}
+				if c >= 0 {
+					sample += current[ch][c]
+				}
+				out[j*v.channels+ch] = sample
+			}
+		}
+	}
+	v.prev, v.prevN, v.prevValid = current, n, true
+	return out, nil
+}
+
+// decodeFloor1 reads the floor 1 amplitude values of one channel along with
+// their step2 flags, or returns nil when the channel is unused.
+func (v *vorbisReader) decodeFloor1(b *lsbBitReader, f *vorbisFloor1) ([]int, []bool) {
+	if b.readBits(1) == 0 {
+		return nil, nil
+	}
+	floorRange := vorbisFloor1Ranges[f.multiplier-1]
+	rangeBits := ilog(uint32(floorRange - 1))
+	y := make([]int, len(f.xList))
+	y[0] = int(b.readBits(rangeBits))
+	y[1] = int(b.readBits(rangeBits))
+	offset := 2
+	for _, class := range f.partitionClasses {
+		dimensions := f.classDimensions[class]
+		bits := uint(f.classSubclasses[class])
+		mask := 1<<bits - 1
+		classValue := 0
+		if bits > 0 {
+			classValue = v.codebooks[f.classMasterbooks[class]].decodeScalar(b)
+		}
+		for j := 0; j < dimensions; j++ {
+			book := f.subclassBooks[class][classValue&mask]
+			classValue >>= bits
+			if book >= 0 {
+				y[offset+j] = v.codebooks[book].decodeScalar(b)
+			}
+		}
+		offset += dimensions
+	}
+	if b.eop {
+		return nil, nil
+	}
+
+	// Amplitude value synthesis: turn the coded deltas into final Y values.
+	final := make([]int, len(y))
+	used := make([]bool, len(y))
+	final[0], final[1] = y[0], y[1]
+	used[0], used[1] = true, true
+	for i := 2; i < len(y); i++ {
+		low, high := f.lowNeighbor[i], f.highNeighbor[i]
+		predicted := renderPoint(f.xList[low], final[low], f.xList[high], final[high], f.xList[i])
+		value := y[i]
+		highRoom := floorRange - predicted
+		lowRoom := predicted
+		room := min(highRoom, lowRoom) * 2
+		if value == 0 {
+			final[i] = predicted
+			continue
+		}
+		used[low], used[high], used[i] = true, true, true
+		switch {
+		case value >= room && highRoom > lowRoom:
+			final[i] = value - lowRoom + predicted
+		case value >= room:
+			final[i] = predicted - value + highRoom - 1
+		case value%2 == 1:
+			final[i] = predicted - (value+1)/2
+		default:
+			final[i] = predicted + value/2
+		}
+	}
+	return final, used
+}
+
+// renderFloor1 draws the floor curve through the used points and converts it to linear amplitudes.
+func renderFloor1(f *vorbisFloor1, final []int, used []bool, curve []float64) {
+	n := len(curve)
+	lx, ly := 0, final[f.sortedOrder[0]]*f.multiplier
+	hx, hy := 0, ly
+	for _, i := range f.sortedOrder[1:] {
+		if !used[i] {
+			continue
+		}
+		hx, hy = f.xList[i], final[i]*f.multiplier
+		renderLine(lx, ly, hx, hy, curve)
+		lx, ly = hx, hy
+	}
+	for x := hx; x < n; x++ {
+		curve[x] = vorbisInverseDBTable[clampFloorIndex(hy)]
+	}
+}
+
+func renderPoint(x0, y0, x1, y1, x int) int {
+	dy := y1 - y0
+	adx := x1 - x0
+	ady := dy
+	if ady < 0 {
+		ady = -ady
+	}
+	offset := ady * (x - x0) / adx
+	if dy < 0 {
+		return y0 - offset
+	}
+	return y0 + offset
+}
+
+func renderLine(x0, y0, x1, y1 int, curve []float64) {
+	dy := y1 - y0
+	adx := x1 - x0
+	if adx <= 0 {
+		return
+	}
+	ady := dy
+	if ady < 0 {
+		ady = -ady
+	}
+	base := dy / adx
+	sy := base + 1
+	if dy < 0 {
+		sy = base - 1
+	}
+	absBase := base
+	if absBase < 0 {
+		absBase = -absBase
+	}
+	ady -= absBase * adx
+
+	y, errAcc := y0, 0
+	if x0 < len(curve) {
+		curve[x0] = vorbisInverseDBTable[clampFloorIndex(y)]
+	}
+	for x := x0 + 1; x < x1; x++ {
+		errAcc += ady
+		if errAcc >= adx {
+			errAcc -= adx
+			y += sy
+		} else {
+			y += base
+		}
+		if x < len(curve) {
+			curve[x] = vorbisInverseDBTable[clampFloorIndex(y)]
+		}
+	}
+}
+
+func clampFloorIndex(y int) int {
+	return max(0, min(255, y))
+}
+
+// decodeResidue decodes one residue into vectors, each of length n.
+func (v *vorbisReader) decodeResidue(b *lsbBitReader, r *vorbisResidue, vectors [][]float64, doNotDecode []bool, n int) {
+	if r.residueType == 2 {
+		decodeAny := false
+		for _, skip := range doNotDecode {
+			decodeAny = decodeAny || !skip
+		}
+		if !decodeAny {
+			return
+		}
+		interleaved := make([]float64, n*len(vectors))
+		v.decodeResiduePartitions(b, r, [][]float64{interleaved}, []bool{false}, len(interleaved))
+		for i, sample := range interleaved {
+			vectors[i%len(vectors)][i/len(vectors)] = sample
+		}
+		return
+	}
+	v.decodeResiduePartitions(b, r, vectors, doNotDecode, n)
+}
+
+func (v *vorbisReader) decodeResiduePartitions(b *lsbBitReader, r *vorbisResidue, vectors [][]float64, doNotDecode []bool, size int) {
+	classbook := &v.codebooks[r.classbook]
+	classwordsPerCodeword := classbook.dimensions
+	begin := min(r.begin, size)
+	end := min(r.end, size)
+	partitionsToRead := (end - begin) / r.partitionSize
+	if partitionsToRead <= 0 || classwordsPerCodeword == 0 {
+		return
+	}
+
+	classifications := make([][]int, len(vectors))
+	for j := range classifications {
+		classifications[j] = make([]int, partitionsToRead+classwordsPerCodeword)
+	}
+
+	for pass := 0; pass < 8; pass++ {
+		partition := 0
+		for partition < partitionsToRead {
+			if pass == 0 {
+				for j := range vectors {
+					if doNotDecode[j] {
+						continue
+					}
+					temp := classbook.decodeScalar(b)
+					if temp < 0 {
+						return
+					}
+					for i := classwordsPerCodeword - 1; i >= 0; i-- {
+						classifications[j][i+partition] = temp % r.classifications
+						temp /= r.classifications
+					}
+				}
+			}
+			for i := 0; i < classwordsPerCodeword && partition < partitionsToRead; i++ {
+				for j := range vectors {
+					if doNotDecode[j] {
+						continue
+					}
+					book := r.books[classifications[j][partition]][pass]
+					if book < 0 {
+						continue
+					}
+					offset := begin + partition*r.partitionSize
+					if !v.decodePartition(b, &v.codebooks[book], r, vectors[j][offset:offset+r.partitionSize]) {
+						return
+					}
+				}
+				partition++
+			}
+		}
+	}
+}
+
+func (v *vorbisReader) decodePartition(b *lsbBitReader, book *vorbisCodebook, r *vorbisResidue, out []float64) bool {
+	dimensions := book.dimensions
+	if r.residueType == 0 {
+		step := len(out) / dimensions
+		for i := 0; i < step; i++ {
+			vector := book.decodeVector(b)
+			if vector == nil {
+				return false
+			}
+			for j, value := range vector {
+				out[i+j*step] += value
+			}
+		}
+		return true
+	}
+	for i := 0; i < len(out); {
+		vector := book.decodeVector(b)
+		if vector == nil {
+			return false
+		}
+		for _, value := range vector {
+			if i >= len(out) {
+				break
+			}
+			out[i] += value
+			i++
+		}
+	}
+	return true
+}
+
+// window returns the Vorbis power-complementary window for a block of size n.
+func (v *vorbisReader) window(n int, prevLong, nextLong, long bool) []float64 {
+	short := v.blocksize[0]
+	leftStart, leftEnd, leftN := 0, n/2, n/2
+	if long && !prevLong {
+		leftStart, leftEnd, leftN = n/4-short/4, n/4+short/4, short/2
+	}
+	rightStart, rightEnd, rightN := n/2, n, n/2
+	if long && !nextLong {
+		rightStart, rightEnd, rightN = n*3/4-short/4, n*3/4+short/4, short/2
+	}
+
+	w := make([]float64, n)
+	for i := leftStart; i < leftEnd; i++ {
+		s := math.Sin((float64(i-leftStart) + 0.5) / float64(leftN) * math.Pi / 2)
+		w[i] = math.Sin(math.Pi / 2 * s * s)
+	}
+	for i := leftEnd; i < rightStart; i++ {
+		w[i] = 1
+	}
+	for i := rightStart; i < rightEnd; i++ {
+		s := math.Sin((float64(i-rightStart)+0.5)/float64(rightN)*math.Pi/2 + math.Pi/2)
+		w[i] = math.Sin(math.Pi / 2 * s * s)
+	}
+	return w
+}
+
+func (v *vorbisReader) imdct(n int) *vorbisIMDCT {
+	t, ok := v.imdcts[n]
+	if !ok {
+		t = newVorbisIMDCT(n)
+		v.imdcts[n] = t
+	}
+	return t
+}
+
+// vorbisIMDCT computes the inverse MDCT of n/2 coefficients into n samples,
+// y[i] = sum X[k] cos(2π/n (i + 1/2 + n/4)(k + 1/2)), with one complex FFT of size n.
+type vorbisIMDCT struct {
+	n      int
+	fft    *fourier.CmplxFFT
+	pre    []complex128
+	post   []complex128
+	buffer []complex128
+}
+
+func newVorbisIMDCT(n int) *vorbisIMDCT {
+	t := &vorbisIMDCT{
+		n:      n,
+		fft:    fourier.NewCmplxFFT(n),
+		pre:    make([]complex128, n/2),
+		post:   make([]complex128, n),
+		buffer: make([]complex128, n),
+	}
+	n0 := 0.5 + float64(n)/4
+	for k := range t.pre {
+		phase := 2 * math.Pi * float64(k) * n0 / float64(n)
+		t.pre[k] = complex(math.Cos(phase), math.Sin(phase))
+	}
+	for i := range t.post {
+		phase := math.Pi * (float64(i) + n0) / float64(n)
+		t.post[i] = complex(math.Cos(phase), math.Sin(phase))
+	}
+	return t
+}
+
+func (t *vorbisIMDCT) transform(coefficients, out []float64) {
+	for k := range t.buffer {
+		t.buffer[k] = 0
+	}
+	for k, c := range coefficients {
+		t.buffer[k] = complex(c, 0) * t.pre[k]
+	}
+	// gonum's Sequence is the unnormalized inverse transform, i.e. sum z[k] e^{+2πi jk/n}.
+	seq := t.fft.Sequence(nil, t.buffer)
+	for i := range out {
+		out[i] = real(seq[i] * t.post[i])
+	}
+}
+
+// lsbBitReader reads Vorbis' least-significant-bit-first fields from a packet.
+// Reading past the end sets eop and returns zero bits, as the specification requires.
+type lsbBitReader struct {
+	data []byte
+	pos  uint
+	eop  bool
+}
+
+func newLSBBitReader(data []byte) *lsbBitReader {
+	return &lsbBitReader{data: data}
+}
+
+func (b *lsbBitReader) readBits(n uint) uint64 {
+	if n == 0 {
+		return 0
+	}
+	if b.pos+n > uint(len(b.data))*8 {
+		b.eop = true
+		b.pos = uint(len(b.data)) * 8
+		return 0
+	}
+	var v uint64
+	for i := uint(0); i < n; {
+		byteIndex := b.pos / 8
+		bitOffset := b.pos % 8
+		take := min(8-bitOffset, n-i)
+		bitsValue := uint64(b.data[byteIndex]>>bitOffset) & (1<<take - 1)
+		v |= bitsValue << i
+		i += take
+		b.pos += take
+	}
+	return v
+}
+
+func (b *lsbBitReader) readString() string {
+	length := int(b.readBits(32))
+	if b.eop || uint(length) > (uint(len(b.data))*8-b.pos)/8 {
+		b.eop = true
+		return ""
+	}
+	var buf bytes.Buffer
+	for i := 0; i < length; i++ {
+		buf.WriteByte(byte(b.readBits(8)))
+	}
+	return buf.String()
+}
+
+// ilog returns the number of bits needed to represent x.
+func ilog(x uint32) uint {
+	n := uint(0)
+	for x > 0 {
+		n++
+		x >>= 1
+	}
+	return n
+}
+
+func float32Unpack(x uint32) float64 {
+	mantissa := float64(x & 0x1FFFFF)
+	if x&0x80000000 != 0 {
+		mantissa = -mantissa
+	}
+	exponent := int(x&0x7FE00000) >> 21
+	return math.Ldexp(mantissa, exponent-788)
+}
+
+// lookup1Values returns the largest r with r^dimensions <= entries.
+func lookup1Values(entries, dimensions int) int {
+	r := int(math.Floor(math.Pow(float64(entries), 1/float64(dimensions))))
+	for intPow(r+1, dimensions) <= entries {
+		r++
+	}
+	for r > 0 && intPow(r, dimensions) > entries {
+		r--
+	}
+	return r
+}
+
+func intPow(base, exp int) int {
+	result := 1
+	for i := 0; i < exp; i++ {
+		result *= base
+		if result > math.MaxInt32 {
+			return result
+		}
+	}
+	return result
+}
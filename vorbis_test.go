@@ -0,0 +1,144 @@
+package goshazam
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+// vorbisFixture is an Ogg Vorbis file in testdata with a reference decoding of its start,
+// as signed 16-bit little-endian interleaved samples.
+type vorbisFixture struct {
+	name, reference string
+	rate, channels  int
+	frames          int64 // decoded length in samples per channel
+}
+
+var vorbisFixtures = []vorbisFixture{
+	{"vorbis_mono.ogg", "vorbis_mono.s16le", 44100, 1, 44100},
+	{"vorbis_stereo.ogg", "vorbis_stereo.s16le", 44100, 2, 72384},
+}
+
+// vorbisTolerance is how far decoded samples may stray from the reference: the rounding
+// of the reference to 16 bits plus the float precision of the decoders.
+const vorbisTolerance = 2.0 / (1 << 15)
+
+func (f vorbisFixture) read(t *testing.T) (data []byte, reference []float64) {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", f.name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := os.ReadFile(filepath.Join("testdata", f.reference))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reference = make([]float64, len(raw)/2)
+	for i := range reference {
+		reference[i] = float64(int16(binary.LittleEndian.Uint16(raw[2*i:]))) / (1 << 15)
+	}
+	return data, reference
+}
+
+// decodeVorbisSamples returns the selected window of r as interleaved samples at the
+// stream's own rate, before they are converted to 16 kHz mono.
+func decodeVorbisSamples(t *testing.T, r io.Reader, opts DecodeOptions) []float64 {
+	t.Helper()
+	stream, err := decodeVorbisStream(r, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var samples []float64
+	for {
+		block, err := stream.next()
+		if errors.Is(err, io.EOF) {
+			return samples
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		samples = append(samples, block...)
+	}
+}
+
+// compareSamples reports the first sample of got that differs from want by more than
+// vorbisTolerance, once clipped to 16 bits like the reference.
+func compareSamples(t *testing.T, what string, got, want []float64) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Errorf("%s: got %d samples, want %d", what, len(got), len(want))
+		return
+	}
+	for i := range got {
+		clipped := max(-1, min(got[i], float64(math.MaxInt16)/(1<<15)))
+		if math.Abs(clipped-want[i]) > vorbisTolerance {
+			t.Errorf("%s: sample %d is %.6f, want %.6f", what, i, got[i], want[i])
+			return
+		}
+	}
+}
+
+func TestDecodeVorbisMatchesReference(t *testing.T) {
+	for _, f := range vorbisFixtures {
+		data, reference := f.read(t)
+		v, err := newVorbisReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v.sampleRate != f.rate || v.channels != f.channels {
+			t.Errorf("%s: got %d Hz with %d channels, want %d Hz with %d", f.name, v.sampleRate, v.channels, f.rate, f.channels)
+		}
+
+		samples := decodeVorbisSamples(t, bytes.NewReader(data), DecodeOptions{})
+		// The last page's granule position cuts the padding off the final packet.
+		if frames := int64(len(samples) / f.channels); frames != f.frames {
+			t.Errorf("%s: decoded %d samples per channel, want %d", f.name, frames, f.frames)
+		}
+		compareSamples(t, f.name, samples[:min(len(samples), len(reference))], reference)
+
+		// Pages split across reads must give the same packets.
+		if got := decodeVorbisSamples(t, iotest.OneByteReader(bytes.NewReader(data)), DecodeOptions{}); !slices.Equal(got, samples) {
+			t.Errorf("%s: reading a byte at a time decoded %d samples differently", f.name, len(got))
+		}
+	}
+}
+
+func TestDecodeVorbisWindow(t *testing.T) {
+	for _, f := range vorbisFixtures {
+		data, reference := f.read(t)
+		for _, opts := range []DecodeOptions{
+			{Duration: 100 * time.Millisecond},
+			{Offset: time.Millisecond, Duration: 10 * time.Millisecond},
+			{Offset: 100 * time.Millisecond, Duration: 200 * time.Millisecond},
+			{Offset: 370 * time.Millisecond, Duration: 120 * time.Millisecond},
+		} {
+			start, count := opts.frames(f.rate)
+			want := reference[start*int64(f.channels) : (start+count)*int64(f.channels)]
+			got := decodeVorbisSamples(t, bytes.NewReader(data), opts)
+			compareSamples(t, f.name+" from "+opts.Offset.String(), got, want)
+		}
+
+		// Windows past the reference are checked against the full decode.
+		full := decodeVorbisSamples(t, bytes.NewReader(data), DecodeOptions{})
+		length := framesDuration(f.frames, f.rate)
+		for _, opts := range []DecodeOptions{
+			{Offset: length - 50*time.Millisecond},
+			{Offset: length + time.Second},
+		} {
+			start, _ := opts.frames(f.rate)
+			want := full[min(start*int64(f.channels), int64(len(full))):]
+			got := decodeVorbisSamples(t, bytes.NewReader(data), opts)
+			if !slices.Equal(got, want) {
+				t.Errorf("%s from %v: got %d samples, want the last %d of the full decode", f.name, opts.Offset, len(got), len(want))
+			}
+		}
+	}
+}
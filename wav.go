@@ -0,0 +1,271 @@
+package goshazam
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+const (
+	wavFormatPCM        = 0x0001
+	wavFormatIEEEFloat  = 0x0003
+	wavFormatExtensible = 0xFFFE
+	wavMaxMetadataChunk = 1 << 20
+)
+
+// wavInfoTags maps the RIFF INFO list IDs to the tag names used by Vorbis comments.
+var wavInfoTags = map[string]string{
+	"INAM": "title",
+	"IART": "artist",
+	"IPRD": "album",
+	"ICRD": "date",
+	"IGNR": "genre",
+	"ICMT": "comment",
+	"ITRK": "tracknumber",
+}
+
+// ErrInvalidWAV is returned when the input is not a well-formed RIFF/WAVE stream.
+var ErrInvalidWAV = errors.New("invalid RIFF/WAVE data")
+
+// WAVFormat describes the sample layout declared in the fmt chunk of a WAV file.
+type WAVFormat struct {
+	AudioFormat   uint16
+	Channels      uint16
+	SampleRate    uint32
+	BitsPerSample uint16
+	BlockAlign    uint16
+}
+
+// IsWAV reports whether header starts with a RIFF/WAVE signature.
+func IsWAV(header []byte) bool {
+	return len(header) >= 12 && bytes.Equal(header[0:4], []byte("RIFF")) && bytes.Equal(header[8:12], []byte("WAVE"))
+}
+
+// WAVDecoder is the native Decoder for RIFF/WAVE input.
+type WAVDecoder struct{}
+
+func (WAVDecoder) Decode(_ context.Context, r io.Reader, opts DecodeOptions) ([]int16, error) {
+	return DecodeWAV(r, opts)
+}
+
+// DecodeStream converts the selected window of r while it is read.
+func (WAVDecoder) DecodeStream(_ context.Context, r io.Reader, opts DecodeOptions) (io.ReadCloser, error) {
+	return decodeWAVStream(r, opts)
+}
+
+// DecodeWAV parses a RIFF/WAVE stream and returns 16 kHz mono samples of the
+// selected window, suitable for SignatureGenerator.MakeSignatureFromBuffer.
+// Audio before the offset is skipped with Seek when r supports it.
+func DecodeWAV(r io.Reader, opts DecodeOptions) ([]int16, error) {
+	pcm, err := decodeWAVStream(r, opts)
+	if err != nil {
+		return nil, err
+	}
+	return readDecoded(pcm)
+}
+
+func decodeWAVStream(r io.Reader, opts DecodeOptions) (*pcmStream, error) {
+	if err := opts.checkSingleStream(); err != nil {
+		return nil, err
+	}
+	header, err := readWAVHeader(r, nil)
+	if err != nil {
+		return nil, err
+	}
+	// Streamed WAV writers leave the size at 0 or 0xFFFFFFFF, so read to EOF in that case.
+	limit := int64(header.dataSize)
+	if header.dataSize == 0 || header.dataSize == math.MaxUint32 {
+		limit = math.MaxInt64
+	}
+	return convertPCMWindow(r, header.format.pcmFormat(), limit, opts)
+}
+
+// wavHeader is what precedes the samples of a WAV file.
+type wavHeader struct {
+	format   WAVFormat
+	dataSize uint32
+}
+
+// readWAVHeader reads the chunks before the data chunk and leaves r at the first sample.
+// Chunks other than fmt are skipped, unless onChunk is set and wants to see them.
+func readWAVHeader(r io.Reader, onChunk func(id string, chunk []byte)) (wavHeader, error) {
+	var header wavHeader
+
+	riffHeader := make([]byte, 12)
+	if _, err := io.ReadFull(r, riffHeader); err != nil {
+		return header, fmt.Errorf("failed to read RIFF header: %w", err)
+	}
+	if !IsWAV(riffHeader) {
+		return header, ErrInvalidWAV
+	}
+
+	haveFormat := false
+	chunkHeader := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, chunkHeader); err != nil {
+			return header, fmt.Errorf("%w: missing data chunk", ErrInvalidWAV)
+		}
+		chunkID := string(chunkHeader[0:4])
+		chunkSize := binary.LittleEndian.Uint32(chunkHeader[4:8])
+
+		switch {
+		case chunkID == "fmt ":
+			if chunkSize < 16 {
+				return header, fmt.Errorf("%w: fmt chunk too short", ErrInvalidWAV)
+			}
+			chunk := make([]byte, int(chunkSize)+int(chunkSize%2))
+			if _, err := io.ReadFull(r, chunk); err != nil {
+				return header, fmt.Errorf("failed to read fmt chunk: %w", err)
+			}
+			var err error
+			header.format, err = parseWAVFormat(chunk[:chunkSize])
+			if err != nil {
+				return header, err
+			}
+			haveFormat = true
+		case chunkID == "data":
+			if !haveFormat {
+				return header, fmt.Errorf("%w: data chunk before fmt chunk", ErrInvalidWAV)
+			}
+			header.dataSize = chunkSize
+			return header, nil
+		case onChunk != nil && chunkSize <= wavMaxMetadataChunk:
+			chunk := make([]byte, int(chunkSize)+int(chunkSize%2))
+			if _, err := io.ReadFull(r, chunk); err != nil {
+				return header, fmt.Errorf("failed to read %q chunk: %w", chunkID, err)
+			}
+			onChunk(chunkID, chunk[:chunkSize])
+		default:
+			if _, err := io.CopyN(io.Discard, r, int64(chunkSize)+int64(chunkSize%2)); err != nil {
+				return header, fmt.Errorf("failed to skip %q chunk: %w", chunkID, err)
+			}
+		}
+	}
+}
+
+func (WAVDecoder) Probe(_ context.Context, path string) (*MediaInfo, error) {
+	return probeFile(path, probeWAV)
+}
+
+// probeWAV reads the WAV header. Tags come from a LIST/INFO chunk placed before the samples.
+func probeWAV(r io.ReadSeeker, size int64) (*MediaInfo, error) {
+	tags := make(map[string]string)
+	header, err := readWAVHeader(r, func(id string, chunk []byte) {
+		if id == "LIST" {
+			parseWAVInfo(chunk, tags)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	format := header.format
+	dataSize := int64(header.dataSize)
+	if header.dataSize == 0 || header.dataSize == math.MaxUint32 {
+		pos, err := r.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+		dataSize = size - pos
+	}
+	return &MediaInfo{
+		Duration:   framesDuration(dataSize/int64(format.BlockAlign), int(format.SampleRate)),
+		Container:  "wav",
+		Codec:      wavCodecName(format),
+		SampleRate: int(format.SampleRate),
+		Channels:   int(format.Channels),
+		Bitrate:    int(format.SampleRate) * int(format.BlockAlign) * 8,
+		Tags:       tags,
+	}, nil
+}
+
+// parseWAVInfo adds the entries of a LIST chunk of type INFO to tags.
+func parseWAVInfo(chunk []byte, tags map[string]string) {
+	if len(chunk) < 4 || string(chunk[:4]) != "INFO" {
+		return
+	}
+	for b := chunk[4:]; len(b) >= 8; {
+		id := string(b[:4])
+		size := int(binary.LittleEndian.Uint32(b[4:8]))
+		if size > len(b)-8 {
+			return
+		}
+		if name, ok := wavInfoTags[id]; ok {
+			addTag(tags, name, strings.TrimSpace(strings.TrimRight(string(b[8:8+size]), "\x00")))
+		}
+		b = b[min(8+size+size%2, len(b)):]
+	}
+}
+
+// wavCodecName returns ffprobe's name for the sample format, e.g. "pcm_s16le".
+func wavCodecName(format WAVFormat) string {
+	return "pcm_" + format.pcmFormat().SampleFormat.String()
+}
+
+func parseWAVFormat(chunk []byte) (WAVFormat, error) {
+	format := WAVFormat{
+		AudioFormat:   binary.LittleEndian.Uint16(chunk[0:2]),
+		Channels:      binary.LittleEndian.Uint16(chunk[2:4]),
+		SampleRate:    binary.LittleEndian.Uint32(chunk[4:8]),
+		BlockAlign:    binary.LittleEndian.Uint16(chunk[12:14]),
+		BitsPerSample: binary.LittleEndian.Uint16(chunk[14:16]),
+	}
+	// WAVE_FORMAT_EXTENSIBLE stores the real format code in the first two bytes of the sub-format GUID.
+	if format.AudioFormat == wavFormatExtensible && len(chunk) >= 26 {
+		format.AudioFormat = binary.LittleEndian.Uint16(chunk[24:26])
+	}
+
+	if format.Channels == 0 || format.SampleRate == 0 {
+		return format, fmt.Errorf("%w: zero channels or sample rate", ErrInvalidWAV)
+	}
+	switch {
+	case format.AudioFormat == wavFormatPCM && (format.BitsPerSample == 8 || format.BitsPerSample == 16 ||
+		format.BitsPerSample == 24 || format.BitsPerSample == 32):
+	case format.AudioFormat == wavFormatIEEEFloat && (format.BitsPerSample == 32 || format.BitsPerSample == 64):
+	default:
+		return format, fmt.Errorf("unsupported WAV format %#04x with %d bits per sample", format.AudioFormat, format.BitsPerSample)
+	}
+	if int(format.BlockAlign) != int(format.Channels)*int(format.BitsPerSample/8) {
+		return format, fmt.Errorf("%w: block align %d does not match %d channels of %d bits",
+			ErrInvalidWAV, format.BlockAlign, format.Channels, format.BitsPerSample)
+	}
+	return format, nil
+}
+
+// pcmFormat returns the raw layout of the samples; parseWAVFormat has validated the combination.
+func (f WAVFormat) pcmFormat() PCMFormat {
+	pcm := PCMFormat{Channels: int(f.Channels), SampleRate: int(f.SampleRate)}
+	switch {
+	case f.AudioFormat == wavFormatIEEEFloat && f.BitsPerSample == 64:
+		pcm.SampleFormat = SampleFormatF64LE
+	case f.AudioFormat == wavFormatIEEEFloat:
+		pcm.SampleFormat = SampleFormatF32LE
+	case f.BitsPerSample == 8:
+		pcm.SampleFormat = SampleFormatU8
+	case f.BitsPerSample == 16:
+		pcm.SampleFormat = SampleFormatS16LE
+	case f.BitsPerSample == 24:
+		pcm.SampleFormat = SampleFormatS24LE
+	default:
+		pcm.SampleFormat = SampleFormatS32LE
+	}
+	return pcm
+}
+
+// skipBytes advances r by n bytes, seeking when possible instead of reading.
+func skipBytes(r io.Reader, n int64) error {
+	if seeker, ok := r.(io.Seeker); ok {
+		_, err := seeker.Seek(n, io.SeekCurrent)
+		return err
+	}
+	_, err := io.CopyN(io.Discard, r, n)
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	return err
+}